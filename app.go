@@ -1,6 +1,15 @@
 package main
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
 
 type App struct {
 	state       AppState
@@ -8,6 +17,26 @@ type App struct {
 	ui          *UI
 	server      *Server
 	activeChat  *Chat
+	chatScreen  *ChatScreen
+	picker      *ReactionPicker
+	contextMenu *ContextMenu
+	linkPicker  *LinkPicker
+	// the search and the screen to go back to from it
+	searchScreen *SearchScreen
+	searchBack   func()
+	reactTo      *Message
+	contacts     *Contacts
+	contact      *Contact
+	form         *FormScreen
+	formBack     func()
+	settings     *Settings
+	history      *InputHistory
+	keys         map[AppState]KeyEventMap
+	recall       *Recall
+	// unread messages in the terminal title
+	unread int
+	// key, mouse and resize events of the terminal, see UI.HandleEvent
+	terminalEvents chan tcell.Event
 }
 
 func NewApp() (*App, error) {
@@ -29,12 +58,37 @@ func (a *App) init() error {
 	}()
 
 	a.inputEvents = make(chan *Event)
+	a.terminalEvents = make(chan tcell.Event)
 	a.setState(appStateStarting)
 
+	a.contacts, err = LoadContacts()
+	if err != nil {
+		return err
+	}
+	a.settings, err = LoadSettings()
+	if err != nil {
+		return err
+	}
+	a.history, err = LoadInputHistory()
+	if err != nil {
+		return err
+	}
+	a.keys, err = LoadKeyBindings()
+	if err != nil {
+		return err
+	}
+
 	a.ui, err = NewUI(a.getKeys)
 	if err != nil {
 		return err
 	}
+	theme, err := LoadTheme(a.settings.Theme)
+	if err != nil {
+		// A broken theme file shouldn't keep the app from starting
+		log.Print("Load theme ", err)
+		theme, err = LoadTheme(defaultTheme)
+	}
+	a.ui.ApplyTheme(theme)
 	a.ui.SetScreen(NewStartScreen(a.ui, &startMenu), false, true)
 
 	return nil
@@ -49,13 +103,24 @@ func (a *App) Loop() {
 	}()
 	log.Print("Loop")
 
-	go a.ui.Listen(a.inputEvents)
+	go a.ui.Listen(a.terminalEvents)
 	a.drawUI()
+	// Relative times and typing durations on the screen get old
+	clock := time.NewTicker(time.Second)
+	defer clock.Stop()
 	for a.state != appStateEnded {
 		select {
 		case e := <-a.inputEvents:
 			log.Print("Dispatch Input Event ", e)
 			a.dispatchEvent(e)
+		case ev := <-a.terminalEvents:
+			if e := a.ui.HandleEvent(ev); e != nil {
+				a.dispatchEvent(e)
+			}
+		case <-clock.C:
+			if a.chatScreen != nil && a.ui.screen == a.chatScreen {
+				a.drawUI()
+			}
 		}
 	}
 	log.Print("End loop")
@@ -68,12 +133,19 @@ func (a *App) Destroy() {
 }
 
 func (a *App) getKeys() *KeyEventMap {
-	m := stateEventMap[a.state]
+	m := a.keys[a.state]
 	return &m
 }
 
 func (a *App) drawUI() {
+	if a.state == appStateSidebar && !a.chatScreen.Wide() {
+		// The terminal got too narrow for the chat list
+		a.focusNext()
+		return
+	}
 	a.ui.Draw()
+	// The chat on the screen marks its messages seen while drawing
+	a.notifyUnread()
 }
 
 func (a *App) setState(s AppState) {
@@ -94,8 +166,134 @@ func (a *App) dispatchEvent(e *Event) {
 		a.openChat()
 	case &eventSendMessage:
 		a.sendMessage()
-	case &eventTyping:
+	case &eventTyping, &eventPaste:
 		a.typing()
+	case &eventRedraw:
+		a.drawUI()
+	case &eventOpenContacts:
+		a.openContacts()
+	case &eventOpenContact:
+		a.openContact(a.contacts.GetActive())
+	case &eventAddContact:
+		a.editContact(nil)
+	case &eventEditContact:
+		a.editContact(a.contact)
+	case &eventSaveContact:
+		a.saveContact()
+	case &eventDeleteContact:
+		a.deleteContact()
+	case &eventContactChat:
+		a.contactChat()
+	case &eventEditProfile:
+		a.editProfile()
+	case &eventSaveProfile:
+		a.saveProfile()
+	case &eventEditMessage:
+		a.editMessage()
+	case &eventShowHistory:
+		a.showHistory()
+	case &eventDeleteMessage:
+		a.deleteMessage()
+	case &eventUndoSend:
+		a.undoSend()
+	case &eventCommitSends:
+		a.server.CommitSends()
+		a.drawUI()
+	case &eventPackets:
+		a.server.HandlePackets()
+		a.drawUI()
+	case &eventSelectMode:
+		a.selectMode()
+	case &eventReply:
+		a.reply()
+	case &eventThreadedView:
+		a.chatScreen.ToggleThreaded()
+		a.drawUI()
+	case &eventToggleThread:
+		a.chatScreen.ToggleThread()
+		a.drawUI()
+	case &eventReactPicker:
+		a.openReactionPicker()
+	case &eventPickerUp:
+		a.picker.Move(0, -1)
+		a.drawUI()
+	case &eventPickerDown:
+		a.picker.Move(0, 1)
+		a.drawUI()
+	case &eventPickerLeft:
+		a.picker.Move(-1, 0)
+		a.drawUI()
+	case &eventPickerRight:
+		a.picker.Move(1, 0)
+		a.drawUI()
+	case &eventReact:
+		a.react()
+	case &eventContextMenu:
+		a.openContextMenu()
+	case &eventMenuUp, &eventMenuDown, &eventMenuSelect:
+		// Screen menus are handled by the UI, these come from the
+		// context menu and the chat list
+		switch {
+		case a.state == appStateContextMenu && e == &eventMenuSelect:
+			a.contextAction()
+			return
+		case a.state == appStateContextMenu && e == &eventMenuUp:
+			a.contextMenu.MenuUp()
+		case a.state == appStateContextMenu:
+			a.contextMenu.MenuDown()
+		case a.state == appStateLinks && e == &eventMenuSelect:
+			a.copyLink()
+			return
+		case a.state == appStateLinks && e == &eventMenuUp:
+			a.linkPicker.MenuUp()
+		case a.state == appStateLinks:
+			a.linkPicker.MenuDown()
+		case a.state == appStateSidebar && e == &eventMenuSelect:
+			a.server.activeChat = a.chatScreen.SidebarChat()
+			a.openChat()
+			return
+		case a.state == appStateSidebar && e == &eventMenuUp:
+			a.chatScreen.SidebarMove(-1)
+		case a.state == appStateSidebar:
+			a.chatScreen.SidebarMove(1)
+		}
+		a.drawUI()
+	case &eventFocusNext:
+		a.focusNext()
+	case &eventToggleRaw:
+		a.toggleRaw()
+	case &eventShowLinks:
+		a.showLinks()
+	case &eventSearch:
+		a.openSearch("")
+	case &eventOpenSearchHit:
+		a.openSearchHit()
+	case &eventComplete:
+		a.complete()
+	case &eventPausePreview:
+		a.activeChat.TogglePause()
+		a.drawUI()
+	case &eventPreviewMode:
+		a.activeChat.CyclePreviewMode()
+		a.drawUI()
+	case &eventFlushPreviews:
+		a.server.FlushPreviews()
+	case &eventHistoryPrev:
+		a.historyPrev()
+	case &eventHistoryNext:
+		a.historyNext()
+	case &eventSearchHistory:
+		a.searchHistory()
+	case &eventAcceptRecall:
+		a.closeRecall(true)
+	case &eventEditSettings:
+		a.editSettings()
+	case &eventSaveSettings:
+		a.saveSettings()
+	case &eventCreateGroup:
+		a.createGroup()
+	case &eventSaveGroup:
+		a.saveGroup()
 	case &eventBack:
 		a.eventBack()
 	default:
@@ -104,7 +302,7 @@ func (a *App) dispatchEvent(e *Event) {
 }
 
 func (a *App) createServer() {
-	a.server = NewServer()
+	a.server = NewServer(a.contacts, a.settings)
 	a.ui.SetScreen(NewServerScreen(a.ui, a.server, &serverMenu), false, true)
 	a.setState(appStateServer)
 	a.drawUI()
@@ -117,46 +315,718 @@ func (a *App) createChat() {
 	a.drawUI()
 }
 
+func (a *App) createGroup() {
+	a.form = NewFormScreen(a.ui, "Group chat", []FormField{
+		{"Title", ""},
+		{"Members (addresses or contact nicknames, comma separated)", ""},
+	}, &eventSaveGroup)
+	a.showForm(a.showServer)
+}
+
+func (a *App) saveGroup() {
+	var addrs []string
+	for _, m := range splitList(a.form.Value(1)) {
+		addrs = append(addrs, a.contacts.ResolveAddress(m))
+	}
+	if len(addrs) == 0 {
+		a.form.SetError("Add at least one member")
+		a.drawUI()
+		return
+	}
+	c := a.server.CreateGroupChat(strings.TrimSpace(a.form.Value(0)), addrs)
+	a.showChat(c)
+}
+
 func (a *App) connectServer() {
-	c := a.server.GetOrCreateChat(a.ui.typed)
-	a.activeChat = c
-	a.ui.SetScreen(NewChatScreen(a.ui, c), true, false)
-	a.setState(appStateChat)
+	c := a.server.GetOrCreateChat(a.contacts.ResolveAddress(strings.TrimSpace(a.ui.typed)))
+	a.showChat(c)
+}
+
+func (a *App) openContacts() {
+	a.contact = nil
+	a.ui.SetScreen(NewContactsScreen(a.ui, a.contacts, &contactsMenu), false, true)
+	a.setState(appStateContacts)
+	a.drawUI()
+}
+
+func (a *App) openContact(c *Contact) {
+	if c == nil {
+		a.openContacts()
+		return
+	}
+	a.contact = c
+	a.ui.SetScreen(NewContactScreen(a.ui, c, &contactMenu), false, true)
+	a.setState(appStateContact)
+	a.drawUI()
+}
+
+// editContact opens the contact form, nil means a new contact.
+func (a *App) editContact(c *Contact) {
+	a.contact = c
+	if c == nil {
+		c = &Contact{}
+	}
+	a.form = NewFormScreen(a.ui, "Contact", []FormField{
+		{"Nickname", c.Nickname},
+		{"Addresses (comma separated)", strings.Join(c.Addresses, ", ")},
+		{"Keys (comma separated)", strings.Join(c.Keys, ", ")},
+		{"Notes", c.Notes},
+		{"Favourite (yes/no)", formatYesNo(c.Favourite)},
+	}, &eventSaveContact)
+	a.showForm(func() {
+		if a.contact != nil {
+			a.openContact(a.contact)
+		} else {
+			a.openContacts()
+		}
+	})
+}
+
+// showForm opens a.form, back is called when the form is cancelled.
+func (a *App) showForm(back func()) {
+	a.formBack = back
+	a.ui.SetScreen(a.form, true, true)
+	a.setState(appStateForm)
+	a.drawUI()
+}
+
+func (a *App) saveContact() {
+	nickname := strings.TrimSpace(a.form.Value(0))
+	if len(nickname) == 0 {
+		a.form.SetError("Nickname is required")
+		a.drawUI()
+		return
+	}
+	c := a.contact
+	isNew := c == nil
+	if isNew {
+		c = &Contact{}
+	}
+	c.Nickname = nickname
+	c.Addresses = splitList(a.form.Value(1))
+	c.Keys = splitList(a.form.Value(2))
+	c.Notes = strings.TrimSpace(a.form.Value(3))
+	c.Favourite = parseYesNo(a.form.Value(4))
+
+	var err error
+	if isNew {
+		err = a.contacts.Add(c)
+	} else {
+		err = a.contacts.Save()
+	}
+	if err != nil {
+		log.Print("Save contacts ", err)
+		a.form.SetError(err.Error())
+		a.drawUI()
+		return
+	}
+	a.openContact(c)
+}
+
+func (a *App) deleteContact() {
+	if a.contact != nil {
+		if err := a.contacts.Delete(a.contact); err != nil {
+			log.Print("Save contacts ", err)
+		}
+	}
+	a.openContacts()
+}
+
+func (a *App) contactChat() {
+	if a.contact == nil || len(a.contact.Addresses) == 0 {
+		if cs, ok := a.ui.screen.(*ContactScreen); ok {
+			cs.SetError("Contact has no known address")
+			a.drawUI()
+		}
+		return
+	}
+	c := a.server.GetOrCreateChat(a.contact.Addresses[0])
+	a.showChat(c)
+}
+
+func (a *App) editProfile() {
+	a.form = NewFormScreen(a.ui, "Profile", []FormField{
+		{"Display name", a.settings.Name},
+		{"Status", a.settings.Status},
+	}, &eventSaveProfile)
+	a.showForm(a.currentMenu())
+}
+
+func (a *App) saveProfile() {
+	a.settings.Name = strings.TrimSpace(a.form.Value(0))
+	a.settings.Status = strings.TrimSpace(a.form.Value(1))
+	if err := a.settings.Save(); err != nil {
+		log.Print("Save settings ", err)
+		a.form.SetError(err.Error())
+		a.drawUI()
+		return
+	}
+	if a.server != nil {
+		a.server.BroadcastProfile()
+	}
+	a.formBack()
+}
+
+func (a *App) editSettings() {
+	a.form = NewFormScreen(a.ui, "Settings", []FormField{
+		{"Send read receipts (yes/no)", formatYesNo(!a.settings.DisableReadReceipts)},
+		{"Undo send window, seconds (0 to send at once)", strconv.Itoa(a.settings.UndoSendSeconds)},
+		{"Live preview (full/indicator/pause/off)", previewModeKey(a.settings.PreviewMode)},
+		{"Input history across chats (yes/no)", formatYesNo(a.settings.SharedInputHistory)},
+		{"Theme (" + strings.Join(themeNames(), ", ") + " or a file in themes)", a.settings.Theme},
+		{"New message notifications (bell/osc9/osc777/off)", notifyModeKey(a.settings.Notifications)},
+		{"Message times (absolute/relative/off)", timestampModeKey(a.settings.Timestamps)},
+	}, &eventSaveSettings)
+	a.showForm(a.currentMenu())
+}
+
+func (a *App) saveSettings() {
+	undo, err := strconv.Atoi(strings.TrimSpace(a.form.Value(1)))
+	if err != nil || undo < 0 {
+		a.form.SetError("Undo send window must be a number of seconds")
+		a.drawUI()
+		return
+	}
+	mode, ok := previewModeKeys[strings.ToLower(strings.TrimSpace(a.form.Value(2)))]
+	if !ok {
+		a.form.SetError("Live preview must be one of full, indicator, pause or off")
+		a.drawUI()
+		return
+	}
+	notify, ok := notifyModeKeys[strings.ToLower(strings.TrimSpace(a.form.Value(5)))]
+	if !ok {
+		a.form.SetError("New message notifications must be one of bell, osc9, osc777 or off")
+		a.drawUI()
+		return
+	}
+	timestamps, ok := timestampModeKeys[strings.ToLower(strings.TrimSpace(a.form.Value(6)))]
+	if !ok {
+		a.form.SetError("Message times must be one of absolute, relative or off")
+		a.drawUI()
+		return
+	}
+	themeName := strings.TrimSpace(a.form.Value(4))
+	theme, err := LoadTheme(themeName)
+	if err != nil {
+		a.form.SetError(err.Error())
+		a.drawUI()
+		return
+	}
+	a.settings.DisableReadReceipts = !parseYesNo(a.form.Value(0))
+	a.settings.UndoSendSeconds = undo
+	a.settings.PreviewMode = mode
+	a.settings.SharedInputHistory = parseYesNo(a.form.Value(3))
+	a.settings.Theme = themeName
+	a.settings.Notifications = notify
+	a.settings.Timestamps = timestamps
+	if err := a.settings.Save(); err != nil {
+		log.Print("Save settings ", err)
+		a.form.SetError(err.Error())
+		a.drawUI()
+		return
+	}
+	a.ui.ApplyTheme(theme)
+	if a.server != nil {
+		a.server.AnnounceModes()
+	}
+	a.formBack()
+}
+
+// currentMenu returns the function that reopens the menu we came from.
+func (a *App) currentMenu() func() {
+	if a.state == appStateServer {
+		return a.showServer
+	}
+	return a.showStart
+}
+
+func (a *App) showStart() {
+	a.ui.SetScreen(NewStartScreen(a.ui, &startMenu), false, true)
+	a.setState(appStateStarting)
+	a.drawUI()
+}
+
+func (a *App) showServer() {
+	a.ui.SetScreen(NewServerScreen(a.ui, a.server, &serverMenu), false, true)
+	a.setState(appStateServer)
 	a.drawUI()
 }
 
 func (a *App) openChat() {
 	c := a.server.GetActiveChat()
+	a.showChat(c)
+}
+
+func (a *App) showChat(c *Chat) {
 	a.activeChat = c
-	a.ui.SetScreen(NewChatScreen(a.ui, c), true, false)
+	a.chatScreen = NewChatScreen(a.ui, c)
+	a.recall = nil
+	a.returnToChat()
+}
+
+// returnToChat shows the chat screen again keeping its view settings.
+func (a *App) returnToChat() {
+	a.chatScreen.StopSelecting()
+	a.ui.SetScreen(a.chatScreen, true, false)
+	a.ui.EnableMultiline()
+	a.setState(appStateChat)
+	a.drawUI()
+}
+
+func (a *App) selectMode() {
+	if !a.chatScreen.StartSelecting() {
+		return
+	}
+	a.ui.DisableTyping()
+	a.ui.EnableVMenu()
+	a.setState(appStateSelect)
+	a.drawUI()
+}
+
+// selectedMessage leaves the selection mode and returns what was selected.
+func (a *App) selectedMessage() *Message {
+	m := a.chatScreen.selected
+	a.chatScreen.StopSelecting()
+	a.ui.EnableTyping()
+	a.ui.DisableVMenu()
+	a.setState(appStateChat)
+	return m
+}
+
+func (a *App) openReactionPicker() {
+	m := a.selectedMessage()
+	if m == nil || m.deleted || !m.finished {
+		a.drawUI()
+		return
+	}
+	a.reactTo = m
+	a.picker = NewReactionPicker(a.ui)
+	a.ui.DisableTyping()
+	a.ui.SetOverlay(a.picker)
+	a.setState(appStateReact)
+	a.drawUI()
+}
+
+func (a *App) closeReactionPicker() {
+	a.ui.SetOverlay(nil)
+	a.ui.EnableTyping()
+	a.setState(appStateChat)
+	a.drawUI()
+}
+
+func (a *App) react() {
+	a.activeChat.React(a.reactTo, a.picker.Selected())
+	a.closeReactionPicker()
+}
+
+// focusNext moves the focus between the chat and the chat list next to it.
+func (a *App) focusNext() {
+	switch a.state {
+	case appStateChat:
+		if !a.chatScreen.Wide() {
+			return
+		}
+		a.chatScreen.FocusSidebar(true)
+		a.ui.DisableTyping()
+		a.setState(appStateSidebar)
+	case appStateSidebar:
+		a.chatScreen.FocusSidebar(false)
+		a.ui.EnableTyping()
+		a.setState(appStateChat)
+	}
+	a.drawUI()
+}
+
+// complete completes a command in the input, other input moves the focus
+// to the chat list.
+func (a *App) complete() {
+	if !isCommand(a.ui.typed) {
+		a.focusNext()
+		return
+	}
+	a.completeCommand()
+	a.typing()
+	a.drawUI()
+}
+
+// toggleRaw switches between formatted messages and the text with the
+// markup as it was typed.
+func (a *App) toggleRaw() {
+	a.settings.RawText = !a.settings.RawText
+	if err := a.settings.Save(); err != nil {
+		log.Print("Save settings ", err)
+	}
+	a.drawUI()
+}
+
+// openSearch shows the search over all chats with the query typed in.
+func (a *App) openSearch(query string) {
+	switch a.state {
+	case appStateChat:
+		a.searchBack = a.returnToChat
+	case appStateServer:
+		a.searchBack = a.showServer
+	default:
+		return
+	}
+	a.searchScreen = NewSearchScreen(a.ui, a.server, query)
+	a.ui.SetScreen(a.searchScreen, true, true)
+	a.setState(appStateSearch)
+	a.drawUI()
+}
+
+// openSearchHit opens the chat of the message found and selects it there.
+func (a *App) openSearchHit() {
+	hit := a.searchScreen.Selected()
+	if hit == nil {
+		return
+	}
+	for i, c := range a.server.chats {
+		if c == hit.chat {
+			a.server.activeChat = i
+		}
+	}
+	a.showChat(hit.chat)
+	if a.chatScreen.Reveal(hit.msg) {
+		a.ui.DisableTyping()
+		a.ui.EnableVMenu()
+		a.setState(appStateSelect)
+		a.drawUI()
+	}
+}
+
+// showLinks lists the links of the chat to copy one of them.
+func (a *App) showLinks() {
+	links := a.activeChat.Links()
+	if len(links) == 0 {
+		a.ui.SetStatus("No links in this chat")
+		a.drawUI()
+		return
+	}
+	a.linkPicker = NewLinkPicker(a.ui, links)
+	a.ui.DisableTyping()
+	a.ui.SetOverlay(a.linkPicker)
+	a.setState(appStateLinks)
+	a.drawUI()
+}
+
+func (a *App) closeLinks() {
+	a.ui.SetOverlay(nil)
+	a.ui.EnableTyping()
 	a.setState(appStateChat)
+}
+
+func (a *App) copyLink() {
+	url := linkTarget(a.linkPicker.Selected())
+	a.closeLinks()
+	a.ui.Copy(url)
+	a.ui.SetStatus("Link copied to the clipboard")
+	a.drawUI()
+}
+
+// openContextMenu shows the actions for the message clicked in the chat.
+func (a *App) openContextMenu() {
+	m := a.chatScreen.clicked
+	if m == nil || !m.finished || m.deleted {
+		return
+	}
+	switch a.state {
+	case appStateSelect:
+		a.selectedMessage()
+	case appStateChat:
+	default:
+		return
+	}
+	items := messageMenu.items
+	if !m.own {
+		items = items[:len(items)-1]
+	}
+	menu := Menu{items: items, len: len(items)}
+	a.contextMenu = NewContextMenu(a.ui, menu, a.chatScreen.clickX, a.chatScreen.clickY)
+	a.ui.DisableTyping()
+	a.ui.SetOverlay(a.contextMenu)
+	a.setState(appStateContextMenu)
+	a.drawUI()
+}
+
+func (a *App) closeContextMenu() {
+	a.ui.SetOverlay(nil)
+	a.ui.EnableTyping()
+	a.setState(appStateChat)
+}
+
+// contextAction runs the item picked in the context menu. The actions
+// shared with the selection mode act on the clicked message as if it was
+// selected.
+func (a *App) contextAction() {
+	e := a.contextMenu.GetMenuEvent()
+	m := a.chatScreen.clicked
+	a.closeContextMenu()
+	if e == &eventCopyMessage {
+		a.ui.Copy(m.text)
+		a.ui.SetStatus("Message copied to the clipboard")
+		a.drawUI()
+		return
+	}
+	a.chatScreen.selecting = true
+	a.chatScreen.selected = m
+	a.ui.DisableTyping()
+	a.setState(appStateSelect)
+	a.dispatchEvent(e)
+}
+
+func (a *App) reply() {
+	if m := a.selectedMessage(); m != nil && !m.deleted {
+		a.activeChat.ReplyTo(m)
+	}
 	a.drawUI()
 }
 
 func (a *App) typing() {
 	switch a.state {
 	case appStateChat:
-		a.activeChat.Typing(a.ui.typed)
+		if isCommand(a.ui.typed) {
+			// Commands are not streamed to the peer
+			a.activeChat.Typing("", 0)
+		} else {
+			a.activeChat.Typing(a.ui.typed, a.ui.CursorFromEnd())
+		}
+	case appStateRecall:
+		a.searchHistory()
+		return
+	case appStateSearch:
+		a.searchScreen.Update(a.ui.typed)
 	}
+	a.drawUI()
 }
 
+// Pastes longer than this or with several lines are sent only after a
+// confirmation.
+const pasteConfirmLength = 500
+
 func (a *App) sendMessage() {
+	if a.state == appStateConfirmSend {
+		a.closeConfirmSend()
+	} else if p := a.ui.Pasted(); strings.Contains(p, "\n") || utf8.RuneCountInString(p) > pasteConfirmLength {
+		a.ui.DisableTyping()
+		a.ui.SetStatus(fmt.Sprintf("Send the pasted %d lines, %d characters? Enter: send, Esc: keep editing",
+			strings.Count(p, "\n")+1, utf8.RuneCountInString(p)))
+		a.setState(appStateConfirmSend)
+		a.drawUI()
+		return
+	}
 	if a.activeChat != nil {
-		a.activeChat.Send(a.ui.typed)
+		if err := a.history.Add(a.activeChat.historyKey(), a.ui.typed); err != nil {
+			log.Print("Save history ", err)
+		}
+		a.recall = nil
+		line := a.ui.typed
+		// Commands may open another screen with its own input
 		a.ui.ClearTyped()
+		if isCommand(line) {
+			a.runCommand(line)
+		} else {
+			line = strings.TrimPrefix(line, "/")
+			a.activeChat.Send(line)
+		}
 		a.drawUI()
 	}
 }
 
+func (a *App) closeConfirmSend() {
+	a.ui.EnableTyping()
+	a.ui.SetStatus("")
+	a.setState(appStateChat)
+}
+
+func (a *App) editMessage() {
+	if a.activeChat == nil {
+		return
+	}
+	var m *Message
+	if a.state == appStateSelect {
+		if sm := a.selectedMessage(); sm != nil && a.activeChat.EditMessage(sm) {
+			m = sm
+		}
+	} else {
+		m = a.activeChat.StartEdit()
+	}
+	if m == nil {
+		a.ui.ClearTyped()
+	} else {
+		a.ui.SetTyped(m.text)
+	}
+	a.drawUI()
+}
+
+// deleteMessage deletes the selected message or the one picked with Ctrl+E
+// for everyone.
+func (a *App) deleteMessage() {
+	if a.activeChat == nil {
+		return
+	}
+	m := a.activeChat.editing
+	if a.state == appStateSelect {
+		m = a.selectedMessage()
+	}
+	if m == nil || !m.own {
+		a.ui.SetStatus("Pick one of your messages with Ctrl+E or Ctrl+S first")
+		a.drawUI()
+		return
+	}
+	a.activeChat.Delete(m)
+	a.ui.ClearTyped()
+	a.ui.SetStatus("")
+	a.drawUI()
+}
+
+func (a *App) undoSend() bool {
+	if a.activeChat == nil {
+		return false
+	}
+	if len(a.ui.typed) > 0 {
+		// The taken back text would replace what is typed
+		if len(a.activeChat.queued) == 0 {
+			return false
+		}
+		a.ui.SetStatus("Clear the input to take the message back")
+		a.drawUI()
+		return true
+	}
+	text, ok := a.activeChat.UndoSend()
+	if !ok {
+		return false
+	}
+	a.ui.SetTyped(text)
+	a.drawUI()
+	return true
+}
+
+func (a *App) showHistory() {
+	if a.activeChat == nil {
+		return
+	}
+	m := a.activeChat.lastEdited
+	if a.state == appStateSelect {
+		m = a.selectedMessage()
+	}
+	if m == nil {
+		a.ui.SetStatus("No edited messages in this chat")
+		a.drawUI()
+		return
+	}
+	a.ui.SetScreen(NewHistoryScreen(a.ui, m), false, false)
+	a.setState(appStateHistory)
+	a.drawUI()
+}
+
+func (a *App) startRecall() {
+	if a.recall == nil {
+		lines := a.history.Lines(a.activeChat.historyKey(), a.settings.SharedInputHistory)
+		a.recall = NewRecall(lines, a.ui.typed)
+	}
+}
+
+func (a *App) historyPrev() {
+	a.startRecall()
+	if line, ok := a.recall.Prev(); ok {
+		a.ui.SetTyped(line)
+		a.typing()
+	}
+	a.drawUI()
+}
+
+func (a *App) historyNext() {
+	a.startRecall()
+	if line, ok := a.recall.Next(); ok {
+		a.ui.SetTyped(line)
+		a.typing()
+	}
+	a.drawUI()
+}
+
+// searchHistory starts the reverse search, or looks for an older match
+// once it is running. The input holds the query meanwhile.
+func (a *App) searchHistory() {
+	if a.state == appStateChat {
+		a.startRecall()
+		a.recall.draft = a.ui.typed
+		a.ui.ClearTyped()
+		a.ui.DisableMultiline()
+		a.setState(appStateRecall)
+	}
+	line, ok := a.recall.Search(a.ui.typed)
+	status := "(reverse-i-search) "
+	if !ok && len(a.ui.typed) > 0 {
+		status = "(failing reverse-i-search) "
+		line, _ = a.recall.Match()
+	}
+	a.ui.SetStatus(status + line + " · Ctrl+R: older, Enter: use, Esc: cancel")
+	a.drawUI()
+}
+
+// closeRecall ends the reverse search putting the match into the input, or
+// the text typed before the search when cancelled.
+func (a *App) closeRecall(accept bool) {
+	line, ok := a.recall.Match()
+	if !accept || !ok {
+		line = a.recall.draft
+	}
+	a.ui.SetTyped(line)
+	a.ui.SetStatus("")
+	a.ui.EnableMultiline()
+	a.setState(appStateChat)
+	a.typing()
+	a.drawUI()
+}
+
 func (a *App) eventBack() {
 	switch a.state {
 	case appStateServer:
-		a.ui.SetScreen(NewStartScreen(a.ui, &startMenu), false, true)
-		a.setState(appStateStarting)
+		a.showStart()
+	case appStateChat:
+		if a.activeChat.editing != nil {
+			a.activeChat.CancelEdit()
+			a.ui.ClearTyped()
+			a.drawUI()
+			return
+		}
+		if a.activeChat.replyTo != nil {
+			a.activeChat.ReplyTo(nil)
+			a.drawUI()
+			return
+		}
+		if a.undoSend() {
+			return
+		}
+		a.showServer()
+	case appStateSelect:
+		a.selectedMessage()
+		a.drawUI()
+	case appStateReact:
+		a.closeReactionPicker()
+	case appStateRecall:
+		a.closeRecall(false)
+	case appStateConfirmSend:
+		a.closeConfirmSend()
+		a.drawUI()
+	case appStateContextMenu:
+		a.closeContextMenu()
 		a.drawUI()
-	case appStateNewChat, appStateChat:
-		a.ui.SetScreen(NewServerScreen(a.ui, a.server, &serverMenu), false, true)
-		a.setState(appStateServer)
+	case appStateLinks:
+		a.closeLinks()
 		a.drawUI()
+	case appStateSearch:
+		a.searchBack()
+	case appStateSidebar:
+		a.focusNext()
+	case appStateHistory:
+		a.returnToChat()
+	case appStateNewChat, appStateContacts:
+		a.showServer()
+	case appStateContact:
+		a.openContacts()
+	case appStateForm:
+		a.formBack()
 	}
 }
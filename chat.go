@@ -2,35 +2,165 @@ package main
 
 import (
 	"log"
-	"net"
+	"strings"
 	"time"
 )
 
-type Chat struct {
-	remoteAddress      string
-	updAddr            *net.UDPAddr
-	allMessages        []*Message
-	ownMessages        []*Message
-	amountOwnMsgs      uint
+type Member struct {
+	peer               *Peer
 	receivedMessages   []*Message
 	amountReceivedMsgs uint
-	server             *Server
-	// conn          *net.Conn
+	// previewMode is what the member shares with us while typing
+	previewMode PreviewMode
+}
+
+// Draft returns the message the member is typing right now.
+func (m *Member) Draft() *Message {
+	if len(m.receivedMessages) == 0 {
+		return nil
+	}
+	msg := m.receivedMessages[len(m.receivedMessages)-1]
+	if msg.finished || (len(msg.text) == 0 && !msg.typing) {
+		return nil
+	}
+	return msg
+}
+
+type Chat struct {
+	id            string
+	title         string
+	members       []*Member
+	allMessages   []*Message
+	ownMessages   []*Message
+	amountOwnMsgs uint
+	transfers     []*Transfer
+	// own message being edited in the input line
+	editing    *Message
+	lastEdited *Message
+	// own messages waiting for the undo-send window to pass
+	queued []*Message
+	// message the input line replies to
+	replyTo *Message
+	// previewMode overrides the global mode for this chat
+	previewMode   PreviewMode
+	previewPaused bool
+	draft         string
+	draftCursor   int
+	draftAt       time.Time
+	sentDraft     string
+	flushTimer    *time.Timer
+	// unread messages we already notified about
+	notified int
+	server   *Server
 }
 
 func NewChat(s *Server) *Chat {
-	c := Chat{server: s}
+	c := Chat{
+		server:      s,
+		previewMode: previewInherit,
+	}
 	return &c
 }
 
-func (c *Chat) SetAddress(address string) {
-	c.remoteAddress = address
-	addr, err := net.ResolveUDPAddr("udp", address)
-	if err == nil {
-		c.updAddr = addr
-	} else {
-		c.updAddr = nil
+func NewGroupChat(s *Server, id string, title string) *Chat {
+	c := Chat{
+		id:          id,
+		title:       title,
+		server:      s,
+		previewMode: previewInherit,
+	}
+	return &c
+}
+
+func (c *Chat) IsGroup() bool {
+	return len(c.id) > 0
+}
+
+func (c *Chat) AddMember(address string) *Member {
+	if m := c.GetMember(address); m != nil {
+		return m
+	}
+	if address == c.server.address || len(address) == 0 {
+		return nil
+	}
+	m := &Member{peer: c.server.GetPeer(address)}
+	c.members = append(c.members, m)
+	return m
+}
+
+func (c *Chat) GetMember(address string) *Member {
+	for _, m := range c.members {
+		if m.peer.address == address {
+			return m
+		}
+	}
+	return nil
+}
+
+func (c *Chat) MemberNames() []string {
+	names := make([]string, len(c.members))
+	for i, m := range c.members {
+		names[i] = m.peer.Name(c.server.contacts)
+	}
+	return names
+}
+
+func (c *Chat) Name() string {
+	if c.IsGroup() {
+		if len(c.title) > 0 {
+			return c.title
+		}
+		return strings.Join(c.MemberNames(), ", ")
+	}
+	if len(c.members) == 0 {
+		return ""
+	}
+	return c.members[0].peer.Name(c.server.contacts)
+}
+
+func (c *Chat) Label() string {
+	if c.IsGroup() {
+		return "Group " + c.Name()
+	}
+	return "Chat with " + c.Name()
+}
+
+// Status is the status line of the peer in a direct chat.
+func (c *Chat) Status() string {
+	if c.IsGroup() || len(c.members) == 0 {
+		return ""
+	}
+	return c.members[0].peer.status
+}
+
+func (c *Chat) SenderName(address string) string {
+	if address == c.server.address {
+		if len(c.server.settings.Name) > 0 {
+			return c.server.settings.Name
+		}
+		return "me"
+	}
+	return c.server.GetPeer(address).Name(c.server.contacts)
+}
+
+// Drafts returns the in-progress message of every member.
+func (c *Chat) Drafts() []*Message {
+	var drafts []*Message
+	for _, m := range c.members {
+		if d := m.Draft(); d != nil {
+			drafts = append(drafts, d)
+		}
 	}
+	return drafts
+}
+
+// Hello sends our profile and asks members for theirs. In a group chat it
+// also tells every member who else is in the group.
+func (c *Chat) Hello() {
+	c.broadcast(&PackedMsg{
+		Type:    packetHello,
+		Profile: c.server.settings.Profile(),
+	})
 }
 
 func (c *Chat) AddOwnMessage(msg string, sender string) {
@@ -47,10 +177,26 @@ func (c *Chat) AddOwnMessage(msg string, sender string) {
 	c.amountOwnMsgs++
 }
 
-func (c *Chat) AddReceivedMessage(p PackedMsg, sender string) {
-	log.Print("Add msg", p.Msg, p.Order, c.amountReceivedMsgs)
-	if c.amountReceivedMsgs < p.Order+1 {
-		for i := c.amountReceivedMsgs; i <= p.Order; i++ {
+func (c *Chat) AddReceivedMessage(p PackedMsg, member *Member) {
+	log.Print("Add msg", p.Msg, p.Order, member.amountReceivedMsgs)
+	// A finished message finished again is an edit that was given up, for
+	// revision 0 too, and keeps its time, reply and receipt
+	known := p.Order < uint(len(member.receivedMessages))
+	if p.Revision > 0 || known && p.Finished && member.receivedMessages[p.Order].finished {
+		if known {
+			m := member.receivedMessages[p.Order]
+			m.ApplyRevision(p.Msg, p.Revision, p.Finished)
+			m.cursor = p.Cursor
+			if m.Edited() {
+				c.lastEdited = m
+			}
+			c.server.index.Update(c, m)
+		}
+		return
+	}
+	sender := member.peer.address
+	if member.amountReceivedMsgs < p.Order+1 {
+		for i := member.amountReceivedMsgs; i <= p.Order; i++ {
 			var m *Message
 			if i == p.Order {
 				m = NewMessage(
@@ -71,22 +217,346 @@ func (c *Chat) AddReceivedMessage(p PackedMsg, sender string) {
 					false,
 				)
 			}
-			c.receivedMessages = append(c.receivedMessages, m)
+			member.receivedMessages = append(member.receivedMessages, m)
 			c.allMessages = append(c.allMessages, m)
 		}
-		c.amountReceivedMsgs = p.Order + 1
+		member.amountReceivedMsgs = p.Order + 1
 	} else {
-		c.receivedMessages[p.Order].SetText(p.Msg)
-		c.receivedMessages[p.Order].ts = time.Now()
-		c.receivedMessages[p.Order].finished = p.Finished
+		member.receivedMessages[p.Order].SetText(p.Msg)
+		member.receivedMessages[p.Order].ts = time.Now()
+		member.receivedMessages[p.Order].finished = p.Finished
+	}
+	m := member.receivedMessages[p.Order]
+	m.replyTo = p.Reply
+	m.typing = p.Typing
+	m.cursor = p.Cursor
+	if p.Finished || len(p.Msg) == 0 && !p.Typing {
+		m.typingSince = time.Time{}
+	} else if m.typingSince.IsZero() {
+		m.typingSince = time.Now()
+	}
+	if p.Finished {
+		c.sendReceipt(member.peer, p.Order, statusDelivered)
+	}
+	c.server.index.Update(c, m)
+}
+
+func (c *Chat) SetReceipt(p PackedMsg, member *Member) {
+	if p.Order >= uint(len(c.ownMessages)) {
+		return
 	}
+	c.ownMessages[p.Order].SetReceipt(member.peer.address, p.Status, len(c.members))
 }
 
-func (c *Chat) Typing(msg string) {
-	c.server.Send(msg, c.amountOwnMsgs, false, c.updAddr)
+// Unread counts the messages of the other members that weren't on the
+// screen yet.
+func (c *Chat) Unread() int {
+	n := 0
+	for _, m := range c.allMessages {
+		if !m.own && m.finished && !m.seen && !m.deleted {
+			n++
+		}
+	}
+	return n
 }
 
+// MarkSeen is called when a received message is shown on the screen.
+func (c *Chat) MarkSeen(m *Message) {
+	if m.own || !m.finished || m.seen {
+		return
+	}
+	m.seen = true
+	if c.server.settings.DisableReadReceipts {
+		return
+	}
+	c.sendReceipt(c.server.GetPeer(m.sender), m.order, statusRead)
+}
+
+func (c *Chat) sendReceipt(peer *Peer, order uint, s MessageStatus) {
+	c.sendTo(&PackedMsg{
+		Type:   packetReceipt,
+		Order:  order,
+		Status: s,
+	}, peer)
+}
+
+func (c *Chat) FindMessage(ref *PackedRef) *Message {
+	var msgs []*Message
+	if ref.Addr == c.server.address {
+		msgs = c.ownMessages
+	} else if m := c.GetMember(ref.Addr); m != nil {
+		msgs = m.receivedMessages
+	}
+	if ref.Order < uint(len(msgs)) {
+		return msgs[ref.Order]
+	}
+	return nil
+}
+
+// ThreadRoot follows the replies up to the message that started the thread.
+func (c *Chat) ThreadRoot(m *Message) *Message {
+	for i := 0; i < len(c.allMessages) && m.replyTo != nil; i++ {
+		parent := c.FindMessage(m.replyTo)
+		if parent == nil {
+			break
+		}
+		m = parent
+	}
+	return m
+}
+
+func (c *Chat) ReplyTo(m *Message) {
+	c.replyTo = m
+}
+
+// replyRef is the reply reference for the message in the input line.
+func (c *Chat) replyRef() *PackedRef {
+	if c.replyTo == nil {
+		return nil
+	}
+	return c.replyTo.Ref()
+}
+
+// React toggles our reaction to the message.
+func (c *Chat) React(m *Message, emoji string) {
+	on := !m.HasReaction(emoji, c.server.address)
+	m.SetReaction(emoji, c.server.address, on)
+	c.broadcast(&PackedMsg{
+		Type: packetReaction,
+		Reaction: &PackedReaction{
+			Ref:    *m.Ref(),
+			Emoji:  emoji,
+			Remove: !on,
+		},
+	})
+}
+
+func (c *Chat) SetReaction(p PackedMsg) {
+	if p.Reaction == nil {
+		return
+	}
+	if m := c.FindMessage(&p.Reaction.Ref); m != nil && !m.deleted {
+		m.SetReaction(p.Reaction.Emoji, p.Addr, !p.Reaction.Remove)
+	}
+}
+
+// StartEdit picks the own message sent before the one being edited, the
+// last one if nothing is edited yet.
+func (c *Chat) StartEdit() *Message {
+	i := len(c.ownMessages) - 1
+	if c.editing != nil {
+		i = int(c.editing.order) - 1
+		c.CancelEdit()
+	} else {
+		// Drop the draft of a new message on the peer side
+		c.sendDraft("")
+	}
+	for ; i >= 0; i-- {
+		if c.EditMessage(c.ownMessages[i]) {
+			return c.editing
+		}
+	}
+	return nil
+}
+
+// EditMessage puts the own message m under edit.
+func (c *Chat) EditMessage(m *Message) bool {
+	if !m.own || m.deleted || m.queued {
+		return false
+	}
+	if c.editing != nil && c.editing != m {
+		c.CancelEdit()
+	}
+	c.editing = m
+	m.editing = true
+	return true
+}
+
+func (c *Chat) CancelEdit() {
+	m := c.editing
+	if m == nil {
+		return
+	}
+	c.editing = nil
+	m.editing = false
+	c.resetDraft()
+	c.broadcast(&PackedMsg{
+		Msg:      m.text,
+		Order:    m.order,
+		Finished: true,
+		Revision: m.revision,
+	})
+}
+
+func (c *Chat) finishEdit(msg string) {
+	c.resetDraft()
+	m := c.editing
+	c.editing = nil
+	m.ApplyRevision(msg, m.revision+1, true)
+	c.lastEdited = m
+	c.server.index.Update(c, m)
+	c.broadcast(&PackedMsg{
+		Msg:      msg,
+		Order:    m.order,
+		Finished: true,
+		Revision: m.revision,
+	})
+}
+
+// Send finishes the message. With an undo-send window the message is only
+// queued, and with a full live preview the peer keeps seeing it as a draft
+// until the window is over. Other modes clear the draft of the peer.
 func (c *Chat) Send(msg string) {
+	if c.editing != nil {
+		c.finishEdit(msg)
+		return
+	}
+	for _, m := range c.members {
+		if !m.peer.profileReceived {
+			c.Hello()
+			break
+		}
+	}
+	// Only a draft that was streamed in full stays visible while queued
+	streamed := c.PreviewMode() == previewFull && !c.previewPaused
+	c.resetDraft()
 	c.AddOwnMessage(msg, c.server.address)
-	c.server.Send(msg, c.amountOwnMsgs-1, true, c.updAddr)
+	m := c.ownMessages[len(c.ownMessages)-1]
+	m.replyTo = c.replyRef()
+	c.replyTo = nil
+	window := time.Duration(c.server.settings.UndoSendSeconds) * time.Second
+	if window <= 0 {
+		c.finishSend(m)
+		return
+	}
+	p := &PackedMsg{
+		Order: m.order,
+		Reply: m.replyTo,
+	}
+	if streamed {
+		p.Msg = msg
+	}
+	c.broadcast(p)
+	m.queued = true
+	m.sendAt = time.Now().Add(window)
+	c.queued = append(c.queued, m)
+	time.AfterFunc(window, c.server.requestCommit)
+}
+
+func (c *Chat) finishSend(m *Message) {
+	m.queued = false
+	c.server.index.Update(c, m)
+	c.broadcast(&PackedMsg{
+		Msg:      m.text,
+		Order:    m.order,
+		Finished: true,
+		Reply:    m.replyTo,
+	})
+}
+
+// commitSends finishes the queued messages whose window is over.
+func (c *Chat) commitSends(now time.Time) {
+	var queued []*Message
+	for _, m := range c.queued {
+		if now.Before(m.sendAt) {
+			queued = append(queued, m)
+		} else {
+			c.finishSend(m)
+		}
+	}
+	c.queued = queued
+}
+
+// UndoSend takes back the last message if it is still queued and returns
+// its text.
+func (c *Chat) UndoSend() (string, bool) {
+	if len(c.queued) == 0 {
+		return "", false
+	}
+	m := c.queued[len(c.queued)-1]
+	if m != c.ownMessages[len(c.ownMessages)-1] {
+		return "", false
+	}
+	c.queued = c.queued[:len(c.queued)-1]
+	c.ownMessages = c.ownMessages[:len(c.ownMessages)-1]
+	for i := len(c.allMessages) - 1; i >= 0; i-- {
+		if c.allMessages[i] == m {
+			c.allMessages = append(c.allMessages[:i], c.allMessages[i+1:]...)
+			break
+		}
+	}
+	c.amountOwnMsgs--
+	if m.replyTo != nil {
+		c.replyTo = c.FindMessage(m.replyTo)
+	}
+	// The peer saw the message as a draft, it goes back to nothing
+	c.broadcast(&PackedMsg{Order: m.order})
+	return m.text, true
+}
+
+// Clear hides the finished messages of the chat on this side only. Drafts
+// and queued messages stay.
+func (c *Chat) Clear() {
+	var kept []*Message
+	for _, m := range c.allMessages {
+		if m.finished && !m.queued {
+			c.server.index.Remove(m)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	c.allMessages = kept
+}
+
+// Delete tombstones an own message for everyone in the chat.
+func (c *Chat) Delete(m *Message) {
+	if !m.own || m.queued {
+		return
+	}
+	if c.editing == m {
+		c.editing = nil
+	}
+	m.Delete()
+	c.server.index.Update(c, m)
+	c.broadcast(&PackedMsg{
+		Type:  packetDelete,
+		Order: m.order,
+	})
+}
+
+func (c *Chat) DeleteReceived(p PackedMsg, member *Member) {
+	if p.Order < uint(len(member.receivedMessages)) {
+		member.receivedMessages[p.Order].Delete()
+		c.server.index.Update(c, member.receivedMessages[p.Order])
+	}
+}
+
+// broadcast sends the packet to every member of the chat.
+func (c *Chat) broadcast(p *PackedMsg) {
+	c.pack(p)
+	for _, m := range c.members {
+		c.server.sendPacket(p, m.peer.updAddr)
+	}
+}
+
+func (c *Chat) sendTo(p *PackedMsg, peer *Peer) {
+	c.pack(p)
+	c.server.sendPacket(p, peer.updAddr)
+}
+
+// pack tells the receiver which group the packet belongs to and how we
+// share our drafts in this chat.
+func (c *Chat) pack(p *PackedMsg) {
+	p.Mode = c.PreviewMode()
+	if !c.IsGroup() {
+		return
+	}
+	p.Group = &PackedGroup{ID: c.id}
+	if p.Type == packetHello || p.Type == packetMode {
+		p.Group.Title = c.title
+		p.Group.Members = append(p.Group.Members, c.server.address)
+		for _, m := range c.members {
+			p.Group.Members = append(p.Group.Members, m.peer.address)
+		}
+	}
 }
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	quoteLength = 40
+	// the chat list is shown next to the chat on terminals this wide
+	splitMinWidth = 100
+	sidebarWidth  = 28
+)
+
+// chatRow is a message as it is laid out in the message list.
+type chatRow struct {
+	msg   *Message
+	depth int
+	// replies hidden in a collapsed thread
+	replies int
+}
+
+type ChatScreen struct {
+	ui        *UI
+	chat      *Chat
+	selecting bool
+	selected  *Message
+	threaded  bool
+	expanded  map[*Message]bool
+	// messages hidden below the bottom of the list
+	scroll int
+	// the message clicked last and where
+	clicked        *Message
+	clickX, clickY int
+	// the chat list has the focus
+	sidebar       bool
+	sidebarActive int
+}
+
+func NewChatScreen(ui *UI, c *Chat) *ChatScreen {
+	cs := ChatScreen{
+		ui:       ui,
+		chat:     c,
+		expanded: make(map[*Message]bool),
+	}
+	return &cs
+}
+
+func (cs *ChatScreen) StartSelecting() bool {
+	rows := cs.rows()
+	if len(rows) == 0 {
+		return false
+	}
+	cs.selecting = true
+	cs.selected = rows[len(rows)-1].msg
+	return true
+}
+
+func (cs *ChatScreen) StopSelecting() {
+	cs.selecting = false
+	cs.selected = nil
+}
+
+func (cs *ChatScreen) MenuUp() {
+	cs.moveSelection(-1)
+}
+
+func (cs *ChatScreen) MenuDown() {
+	cs.moveSelection(1)
+}
+
+func (cs *ChatScreen) GetMenuEvent() *Event {
+	return &eventReply
+}
+
+func (cs *ChatScreen) moveSelection(d int) {
+	rows := cs.rows()
+	for i, r := range rows {
+		if r.msg == cs.selected {
+			i += d
+			if i >= 0 && i < len(rows) {
+				cs.selected = rows[i].msg
+			}
+			// Keep the selection above the bottom of the list
+			if i < len(rows) && i > len(rows)-1-cs.scroll {
+				cs.scroll = len(rows) - 1 - i
+			}
+			return
+		}
+	}
+}
+
+// Reveal scrolls the list to the message and selects it.
+func (cs *ChatScreen) Reveal(m *Message) bool {
+	rows := cs.rows()
+	for i, r := range rows {
+		if r.msg == m {
+			cs.scroll = len(rows) - 1 - i
+			cs.selecting = true
+			cs.selected = m
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *ChatScreen) Scroll(d int) {
+	cs.scroll += d
+	if n := len(cs.rows()); cs.scroll >= n {
+		cs.scroll = n - 1
+	}
+	if cs.scroll < 0 {
+		cs.scroll = 0
+	}
+}
+
+func (cs *ChatScreen) Click(x, y int) *Event {
+	switch item := cs.ui.ItemAt(x, y).(type) {
+	case *Message:
+		cs.clicked = item
+		cs.clickX, cs.clickY = x, y
+		return &eventContextMenu
+	case int:
+		cs.chat.server.activeChat = item
+		return &eventOpenChat
+	}
+	return nil
+}
+
+// Wide reports whether the chat list fits next to the chat.
+func (cs *ChatScreen) Wide() bool {
+	w, _ := cs.ui.tcs.Size()
+	return w >= splitMinWidth
+}
+
+// FocusSidebar moves the focus to the chat list and back.
+func (cs *ChatScreen) FocusSidebar(on bool) {
+	cs.sidebar = on
+	if !on {
+		return
+	}
+	for i, c := range cs.chat.server.chats {
+		if c == cs.chat {
+			cs.sidebarActive = i
+		}
+	}
+}
+
+func (cs *ChatScreen) SidebarMove(d int) {
+	n := len(cs.chat.server.chats)
+	if n > 0 {
+		cs.sidebarActive = (cs.sidebarActive + d + n) % n
+	}
+}
+
+// SidebarChat is the chat selected in the chat list.
+func (cs *ChatScreen) SidebarChat() int {
+	return cs.sidebarActive
+}
+
+func (cs *ChatScreen) drawSidebar() {
+	cs.ui.SetViewport(0, sidebarWidth)
+	style := footerStyle
+	if cs.sidebar {
+		style = titleStyle
+	}
+	cs.ui.DrawText("Chats (Tab)", style, false)
+	for i, c := range cs.chat.server.chats {
+		label := "  " + c.Name()
+		if c == cs.chat {
+			label = "▶ " + c.Name()
+		}
+		if len(c.Drafts()) > 0 {
+			label += " ✎"
+		}
+		if n := c.Unread(); n > 0 {
+			label += fmt.Sprintf(" (%d)", n)
+		}
+		style := menuItemStyle
+		if cs.sidebar && i == cs.sidebarActive {
+			style = menuActiveItemStyle
+		}
+		top := cs.ui.topRows
+		cs.ui.DrawText(truncate(label, sidebarWidth), style, false)
+		cs.ui.Mark(top, cs.ui.topRows, i)
+	}
+	_, h := cs.ui.tcs.Size()
+	for y := 0; y < h-cs.ui.reservedRows; y++ {
+		cs.ui.DrawCells(sidebarWidth, y, "│", footerStyle)
+	}
+	cs.ui.SetViewport(sidebarWidth+1, 0)
+}
+
+func (cs *ChatScreen) ToggleThreaded() {
+	cs.threaded = !cs.threaded
+}
+
+// ToggleThread expands or collapses the thread of the selected message.
+func (cs *ChatScreen) ToggleThread() {
+	if cs.selected == nil {
+		return
+	}
+	root := cs.chat.ThreadRoot(cs.selected)
+	cs.expanded[root] = !cs.expanded[root]
+	cs.selected = root
+}
+
+// rows lists the messages to show, drafts excluded. The threaded view
+// keeps replies under the message that started the thread and collapses
+// the threads that are not expanded.
+func (cs *ChatScreen) rows() []chatRow {
+	isDraft := make(map[*Message]bool)
+	for _, d := range cs.chat.Drafts() {
+		isDraft[d] = true
+	}
+	var rows []chatRow
+	if !cs.threaded {
+		for _, m := range cs.chat.allMessages {
+			if !isDraft[m] {
+				rows = append(rows, chatRow{msg: m})
+			}
+		}
+		return rows
+	}
+
+	var roots []*Message
+	replies := make(map[*Message][]*Message)
+	for _, m := range cs.chat.allMessages {
+		if isDraft[m] {
+			continue
+		}
+		root := cs.chat.ThreadRoot(m)
+		if root == m {
+			roots = append(roots, m)
+		} else {
+			replies[root] = append(replies[root], m)
+		}
+	}
+	for _, root := range roots {
+		if !cs.expanded[root] {
+			rows = append(rows, chatRow{msg: root, replies: len(replies[root])})
+			continue
+		}
+		rows = append(rows, chatRow{msg: root})
+		for _, m := range replies[root] {
+			rows = append(rows, chatRow{msg: m, depth: 1})
+		}
+	}
+	return rows
+}
+
+func (cs *ChatScreen) Draw() {
+	if cs.Wide() {
+		cs.drawSidebar()
+	}
+	title := cs.chat.Label()
+	if status := cs.chat.Status(); len(status) > 0 {
+		title += " — " + status
+	}
+	if cs.threaded {
+		title += " [threads]"
+	}
+	if cs.chat.server.settings.RawText {
+		title += " [raw]"
+	}
+	if !cs.chat.IsGroup() && len(cs.chat.members) > 0 {
+		if mode := cs.chat.members[0].previewMode; mode != previewFull {
+			title += " · they share: " + previewModeNames[mode]
+		}
+	}
+	cs.ui.DrawText(title, titleStyle, false)
+	if cs.chat.IsGroup() {
+		names := cs.chat.MemberNames()
+		for i, m := range cs.chat.members {
+			if m.previewMode != previewFull {
+				names[i] += " (" + previewModeNames[m.previewMode] + ")"
+			}
+		}
+		cs.ui.DrawText("Members: "+strings.Join(names, ", "), footerStyle, false)
+	}
+	if cs.sidebar {
+		cs.ui.DrawTextBottom("Up/Down: pick a chat, Enter: open it, Tab/Esc: back to the chat", footerStyle, false)
+	} else if cs.selecting {
+		cs.ui.DrawTextBottom("Up/Down: select, Enter/r: reply, +: react, e: edit, d: delete, h: revisions, o: open thread, Esc: done", footerStyle, false)
+	} else {
+		cs.ui.DrawTextBottom(cs.ui.typed, inputStyle, true)
+		if mode := cs.chat.PreviewMode(); mode != previewFull || cs.chat.previewPaused {
+			preview := "Preview: " + previewModeNames[mode]
+			if cs.chat.previewPaused {
+				preview += ", paused"
+			}
+			cs.ui.DrawTextBottom(preview+" · Ctrl+O: mode, Ctrl+P: pause", footerStyle, false)
+		}
+		if m := cs.chat.replyTo; m != nil {
+			cs.ui.DrawTextBottom("↪ Replying to "+cs.quote(m)+" (Esc to cancel)", footerStyle, false)
+		}
+	}
+
+	transfers := cs.chat.transfers
+	first := 0
+	if len(transfers) > transferShown {
+		first = len(transfers) - transferShown
+	}
+	for i := len(transfers) - 1; i >= first; i-- {
+		cs.ui.DrawTextBottom(fmt.Sprintf("[%d] %s", i+1, transfers[i]), footerStyle, false)
+	}
+
+	// Drafts of every member stay pinned above the input
+	now := time.Now()
+	drafts := cs.chat.Drafts()
+	for i := len(drafts) - 1; i >= 0; i-- {
+		header := cs.chat.SenderName(drafts[i].sender) + " · typing for " + formatDuration(now.Sub(drafts[i].typingSince))
+		cs.drawMsg(chatRow{msg: drafts[i]}, receivedMsgStyle, header)
+	}
+	rows := cs.rows()
+	last := len(rows) - 1 - cs.scroll
+	if cs.scroll > 0 {
+		cs.ui.DrawTextBottom(fmt.Sprintf("↓ %d newer messages", cs.scroll), footerStyle, false)
+	}
+	for i := last; i >= 0; i-- {
+		style := receivedMsgStyle
+		if rows[i].msg.own {
+			style = myMsgStyle
+		}
+		if cs.selecting && rows[i].msg == cs.selected {
+			style = menuActiveItemStyle
+		}
+		var prev *chatRow
+		if i > 0 {
+			prev = &rows[i-1]
+		}
+		header := ""
+		if startsGroup(prev, rows[i]) {
+			header = cs.chat.SenderName(rows[i].msg.sender)
+			if ts := formatTimestamp(rows[i].msg.ts, cs.chat.server.settings.Timestamps, now); len(ts) > 0 {
+				header += " · " + ts
+			}
+		}
+		cs.drawMsg(rows[i], style, header)
+		if prev == nil || !sameDay(prev.msg.ts, rows[i].msg.ts) {
+			cs.ui.DrawTextBottom("── "+formatDay(rows[i].msg.ts, now)+" ──", footerStyle, false)
+		}
+	}
+}
+
+// startsGroup reports whether the row needs a header with the sender and
+// the time, that is when it isn't a quick follow-up of the row before it.
+func startsGroup(prev *chatRow, r chatRow) bool {
+	if prev == nil || prev.depth != r.depth {
+		return true
+	}
+	p, m := prev.msg, r.msg
+	return p.sender != m.sender || !sameDay(p.ts, m.ts) || m.ts.Sub(p.ts) > groupGap
+}
+
+// drawMsg draws the message with the header above it unless the header is
+// empty.
+func (cs *ChatScreen) drawMsg(r chatRow, style tcell.Style, header string) {
+	m := r.msg
+	msg := m.text
+	if !m.finished {
+		msg = withCaret(msg, m.cursor) + "..."
+	}
+	switch {
+	case m.deleted:
+		msg = "(message deleted)"
+	case m.queued:
+		msg = msg + " (sending, Esc or Ctrl+Z to undo)"
+	case m.editing && m.own:
+		msg = msg + " (editing)"
+	case m.editing:
+		msg = withCaret(m.editText, m.cursor) + " ✎..."
+	case m.Edited():
+		msg = msg + " (edited)"
+	}
+	if m.own && !m.deleted && !m.queued {
+		msg = msg + " " + statusGlyphs[m.status]
+	}
+	indent := strings.Repeat("  ", r.depth)
+	msg = strings.ReplaceAll(msg, "\n", "\n"+indent)
+	bottom := cs.ui.BottomRow()
+	if len(m.reactions) > 0 && !m.deleted {
+		cs.ui.DrawTextBottom(indent+m.ReactionsLine(), footerStyle, false)
+	}
+	if r.replies > 0 {
+		cs.ui.DrawTextBottom(fmt.Sprintf("%s  ↳ %d replies", indent, r.replies), footerStyle, false)
+	}
+	mode := markupClosed
+	switch {
+	case cs.chat.server.settings.RawText || m.deleted:
+		mode = markupRaw
+	case !m.finished:
+		mode = markupProgressive
+	}
+	if cs.ui.DrawRowsBottom(m.layout.Wrap(indent+msg, cs.ui.Width(), mode), style) {
+		cs.chat.MarkSeen(m)
+	}
+	if m.replyTo != nil && !m.deleted {
+		quoted := "message that is not here"
+		if q := cs.chat.FindMessage(m.replyTo); q != nil {
+			quoted = cs.quote(q)
+		}
+		cs.ui.DrawTextBottom(indent+"┌ "+quoted, footerStyle, false)
+	}
+	if len(header) > 0 {
+		cs.ui.DrawTextBottom(indent+header, footerStyle, false)
+	}
+	cs.ui.Mark(cs.ui.BottomRow(), bottom, m)
+}
+
+func (cs *ChatScreen) quote(m *Message) string {
+	text := m.text
+	if m.deleted {
+		text = "(message deleted)"
+	}
+	return cs.chat.SenderName(m.sender) + ": " + truncate(text, quoteLength)
+}
+
+// withCaret marks where the peer's cursor is in a draft unless it is at
+// the end.
+func withCaret(s string, fromEnd int) string {
+	runes := []rune(s)
+	if fromEnd <= 0 || fromEnd > len(runes) {
+		return s
+	}
+	i := len(runes) - fromEnd
+	return string(runes[:i]) + "▏" + string(runes[i:])
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CommandLine is a command typed in the chat input, split into words.
+type CommandLine struct {
+	name string
+	args []string
+	// everything after the name, for arguments with spaces like paths
+	rest string
+}
+
+func ParseCommandLine(line string) CommandLine {
+	fields := strings.Fields(line)
+	cl := CommandLine{name: strings.TrimPrefix(fields[0], "/"), args: fields[1:]}
+	cl.rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimLeft(line, " "), fields[0]))
+	return cl
+}
+
+// Command is run by typing "/<name> <args>" in a chat.
+type Command struct {
+	name  string
+	usage string
+	help  string
+	run   func(a *App, cl CommandLine) error
+	// complete returns the values the argument being typed can take, those
+	// not starting with the word are left out later
+	complete func(a *App, word string) []string
+	// the argument is the whole rest of the line, spaces included, like a
+	// path
+	wholeArg bool
+}
+
+// commands are all the commands by name. Parts of the app add their own
+// with registerCommand in an init function.
+var commands = make(map[string]*Command)
+
+func registerCommand(c *Command) {
+	if _, ok := commands[c.name]; ok {
+		panic("command /" + c.name + " registered twice")
+	}
+	commands[c.name] = c
+}
+
+func commandNames() []string {
+	var names []string
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isCommand reports whether the input is a command. Two slashes send a
+// message starting with one.
+func isCommand(line string) bool {
+	return strings.HasPrefix(line, "/") && !strings.HasPrefix(line, "//")
+}
+
+func (a *App) runCommand(line string) {
+	cl := ParseCommandLine(line)
+	c, ok := commands[cl.name]
+	if !ok {
+		a.ui.SetStatus(fmt.Sprintf("Unknown command /%s, /help lists them", cl.name))
+		return
+	}
+	a.ui.SetStatus("")
+	if err := c.run(a, cl); err != nil {
+		a.ui.SetStatus(err.Error())
+	}
+}
+
+// completeCommand completes the command name or its last argument in the
+// input as far as the candidates agree, and lists them when there are
+// several.
+func (a *App) completeCommand() {
+	line := a.ui.typed
+	var candidates []string
+	word := line[strings.LastIndex(line, " ")+1:]
+	if !strings.Contains(line, " ") {
+		for _, n := range commandNames() {
+			candidates = append(candidates, "/"+n)
+		}
+	} else if c, ok := commands[ParseCommandLine(line).name]; ok && c.complete != nil {
+		if c.wholeArg {
+			word = strings.TrimLeft(line[strings.Index(line, " "):], " ")
+		}
+		candidates = c.complete(a, word)
+	}
+	var found []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			found = append(found, c)
+		}
+	}
+	switch len(found) {
+	case 0:
+		a.ui.SetStatus("Nothing to complete")
+		return
+	case 1:
+		// A finished argument gets a space, a directory doesn't
+		if !strings.HasSuffix(found[0], "/") {
+			found[0] += " "
+		}
+		a.ui.SetTyped(line[:len(line)-len(word)] + found[0])
+		a.ui.SetStatus("")
+		return
+	}
+	a.ui.SetTyped(line[:len(line)-len(word)] + commonPrefix(found))
+	a.ui.SetStatus(strings.Join(found, "  "))
+}
+
+// commonPrefix is the longest prefix of whole characters all the strings
+// share.
+func commonPrefix(s []string) string {
+	prefix := []rune(s[0])
+	for _, v := range s[1:] {
+		for !strings.HasPrefix(v, string(prefix)) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return string(prefix)
+}
+
+func usageError(c *Command) error {
+	return fmt.Errorf("usage: /%s %s", c.name, c.usage)
+}
+
+func init() {
+	registerCommand(&Command{
+		name:  "help",
+		usage: "[command]",
+		help:  "lists the commands or tells what one does",
+		run: func(a *App, cl CommandLine) error {
+			if len(cl.args) == 0 {
+				a.ui.SetStatus("Commands: /" + strings.Join(commandNames(), ", /") + ". Tab completes them.")
+				return nil
+			}
+			c, ok := commands[strings.TrimPrefix(cl.args[0], "/")]
+			if !ok {
+				return fmt.Errorf("no command %s", cl.args[0])
+			}
+			a.ui.SetStatus(fmt.Sprintf("/%s %s: %s", c.name, c.usage, c.help))
+			return nil
+		},
+		complete: func(a *App, word string) []string {
+			return commandNames()
+		},
+	})
+	registerCommand(&Command{
+		name:  "nick",
+		usage: "<name>",
+		help:  "changes the name the others see",
+		run: func(a *App, cl CommandLine) error {
+			if len(cl.rest) == 0 {
+				return usageError(commands["nick"])
+			}
+			a.settings.Name = cl.rest
+			if err := a.settings.Save(); err != nil {
+				return err
+			}
+			a.server.BroadcastProfile()
+			return nil
+		},
+	})
+	registerCommand(&Command{
+		name:  "connect",
+		usage: "<address or contact>",
+		help:  "opens the chat with the address or the contact",
+		run: func(a *App, cl CommandLine) error {
+			if len(cl.rest) == 0 {
+				return usageError(commands["connect"])
+			}
+			a.showChat(a.server.GetOrCreateChat(a.contacts.ResolveAddress(cl.rest)))
+			return nil
+		},
+		complete: func(a *App, word string) []string {
+			var names []string
+			for _, c := range a.contacts.list {
+				names = append(names, c.Nickname)
+			}
+			return names
+		},
+	})
+	registerCommand(&Command{
+		name: "close",
+		help: "closes the chat and leaves it out of the chat list",
+		run: func(a *App, cl CommandLine) error {
+			a.server.CloseChat(a.activeChat)
+			a.activeChat = nil
+			a.showServer()
+			return nil
+		},
+	})
+	registerCommand(&Command{
+		name:  "me",
+		usage: "<action>",
+		help:  "sends an action, like \"* alice waves\"",
+		run: func(a *App, cl CommandLine) error {
+			if len(cl.rest) == 0 {
+				return usageError(commands["me"])
+			}
+			a.activeChat.Send("* " + a.activeChat.SenderName(a.server.address) + " " + cl.rest)
+			return nil
+		},
+	})
+	registerCommand(&Command{
+		name: "clear",
+		help: "hides the messages of the chat so far",
+		run: func(a *App, cl CommandLine) error {
+			a.activeChat.Clear()
+			return nil
+		},
+	})
+	registerCommand(&Command{
+		name: "quit",
+		help: "exits livechat",
+		run: func(a *App, cl CommandLine) error {
+			a.setState(appStateEnded)
+			return nil
+		},
+	})
+}
@@ -8,6 +8,22 @@ const (
 	appStateServer   AppState = 2
 	appStateNewChat  AppState = 3
 	appStateChat     AppState = 4
+	appStateContacts AppState = 5
+	appStateContact  AppState = 6
+	appStateForm     AppState = 7
+	appStateHistory  AppState = 8
+	appStateSelect   AppState = 9
+	appStateReact    AppState = 10
+	// reverse search in the input history
+	appStateRecall AppState = 11
+	// a large paste waits for confirmation before it is sent
+	appStateConfirmSend AppState = 12
+	appStateContextMenu AppState = 13
+	// the chat list next to the chat has the focus
+	appStateSidebar AppState = 14
+	// the links of the chat are listed to be copied
+	appStateLinks  AppState = 15
+	appStateSearch AppState = 16
 )
 
 // App Events
@@ -20,10 +36,56 @@ var (
 	eventCreateChat    = Event{"createChat"}
 	eventConnectServer = Event{"connectServer"}
 	eventUpdateChats   = Event{"updateChats"}
+	eventPackets       = Event{"packets"}
 	eventBack          = Event{"back"}
 	eventTyping        = Event{"typing"}
+	eventRedraw        = Event{"redraw"}
 	eventSendMessage   = Event{"sendMessage"}
 	eventOpenChat      = Event{"openChat"}
+	eventOpenContacts  = Event{"openContacts"}
+	eventOpenContact   = Event{"openContact"}
+	eventAddContact    = Event{"addContact"}
+	eventEditContact   = Event{"editContact"}
+	eventDeleteContact = Event{"deleteContact"}
+	eventSaveContact   = Event{"saveContact"}
+	eventContactChat   = Event{"contactChat"}
+	eventEditProfile   = Event{"editProfile"}
+	eventSaveProfile   = Event{"saveProfile"}
+	eventCreateGroup   = Event{"createGroup"}
+	eventSaveGroup     = Event{"saveGroup"}
+	eventEditSettings  = Event{"editSettings"}
+	eventSaveSettings  = Event{"saveSettings"}
+	eventEditMessage   = Event{"editMessage"}
+	eventShowHistory   = Event{"showHistory"}
+	eventDeleteMessage = Event{"deleteMessage"}
+	eventUndoSend      = Event{"undoSend"}
+	eventCommitSends   = Event{"commitSends"}
+	eventSelectMode    = Event{"selectMode"}
+	eventReply         = Event{"reply"}
+	eventThreadedView  = Event{"threadedView"}
+	eventToggleThread  = Event{"toggleThread"}
+	eventReactPicker   = Event{"reactPicker"}
+	eventReact         = Event{"react"}
+	eventPickerUp      = Event{"pickerUp"}
+	eventPickerDown    = Event{"pickerDown"}
+	eventPickerLeft    = Event{"pickerLeft"}
+	eventPickerRight   = Event{"pickerRight"}
+	eventPausePreview  = Event{"pausePreview"}
+	eventPreviewMode   = Event{"previewMode"}
+	eventFlushPreviews = Event{"flushPreviews"}
+	eventHistoryPrev   = Event{"historyPrev"}
+	eventHistoryNext   = Event{"historyNext"}
+	eventSearchHistory = Event{"searchHistory"}
+	eventAcceptRecall  = Event{"acceptRecall"}
+	eventPaste         = Event{"paste"}
+	eventContextMenu   = Event{"contextMenu"}
+	eventCopyMessage   = Event{"copyMessage"}
+	eventFocusNext     = Event{"focusNext"}
+	eventToggleRaw     = Event{"toggleRaw"}
+	eventShowLinks     = Event{"showLinks"}
+	eventSearch        = Event{"search"}
+	eventOpenSearchHit = Event{"openSearchHit"}
+	eventComplete      = Event{"complete"}
 )
 
 var stateEventMap = map[AppState]KeyEventMap{
@@ -56,11 +118,197 @@ var stateEventMap = map[AppState]KeyEventMap{
 		"Esc": {
 			event: &eventBack,
 		},
+		"Ctrl+E": {
+			event: &eventEditMessage,
+		},
+		"F2": {
+			event: &eventShowHistory,
+		},
+		"Ctrl+D": {
+			event: &eventDeleteMessage,
+		},
+		"Ctrl+Z": {
+			event: &eventUndoSend,
+		},
+		"Ctrl+S": {
+			event: &eventSelectMode,
+		},
+		"Ctrl+T": {
+			event: &eventThreadedView,
+		},
+		"Ctrl+P": {
+			event: &eventPausePreview,
+		},
+		"Ctrl+O": {
+			event: &eventPreviewMode,
+		},
+		"Up": {
+			event: &eventHistoryPrev,
+		},
+		"Down": {
+			event: &eventHistoryNext,
+		},
+		"Ctrl+R": {
+			event: &eventSearchHistory,
+		},
+		"Tab": {
+			event: &eventComplete,
+		},
+		"F3": {
+			event: &eventToggleRaw,
+		},
+		"Ctrl+L": {
+			event: &eventShowLinks,
+		},
+		"Ctrl+F": {
+			event: &eventSearch,
+		},
+	},
+	appStateLinks: {
+		"Up": {
+			event: &eventMenuUp,
+		},
+		"Down": {
+			event: &eventMenuDown,
+		},
+		"Enter": {
+			event: &eventMenuSelect,
+		},
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateSidebar: {
+		"Up": {
+			event: &eventMenuUp,
+		},
+		"Down": {
+			event: &eventMenuDown,
+		},
+		"Enter": {
+			event: &eventMenuSelect,
+		},
+		"Tab": {
+			event: &eventFocusNext,
+		},
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateConfirmSend: {
+		"Enter": {
+			event: &eventSendMessage,
+		},
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateRecall: {
+		"Ctrl+R": {
+			event: &eventSearchHistory,
+		},
+		"Enter": {
+			event: &eventAcceptRecall,
+		},
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateSelect: {
+		"Esc": {
+			event: &eventBack,
+		},
+		"r": {
+			event: &eventReply,
+		},
+		"e": {
+			event: &eventEditMessage,
+		},
+		"d": {
+			event: &eventDeleteMessage,
+		},
+		"h": {
+			event: &eventShowHistory,
+		},
+		"o": {
+			event: &eventToggleThread,
+		},
+		"+": {
+			event: &eventReactPicker,
+		},
+		"Ctrl+T": {
+			event: &eventThreadedView,
+		},
+		"F3": {
+			event: &eventToggleRaw,
+		},
+	},
+	appStateReact: {
+		"Up": {
+			event: &eventPickerUp,
+		},
+		"Down": {
+			event: &eventPickerDown,
+		},
+		"Left": {
+			event: &eventPickerLeft,
+		},
+		"Right": {
+			event: &eventPickerRight,
+		},
+		"Enter": {
+			event: &eventReact,
+		},
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateContextMenu: {
+		"Up": {
+			event: &eventMenuUp,
+		},
+		"Down": {
+			event: &eventMenuDown,
+		},
+		"Enter": {
+			event: &eventMenuSelect,
+		},
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateHistory: {
+		"Esc": {
+			event: &eventBack,
+		},
 	},
 	appStateServer: {
 		"Esc": {
 			event: &eventBack,
 		},
+		"Ctrl+F": {
+			event: &eventSearch,
+		},
+	},
+	appStateSearch: {
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateContacts: {
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateContact: {
+		"Esc": {
+			event: &eventBack,
+		},
+	},
+	appStateForm: {
+		"Esc": {
+			event: &eventBack,
+		},
 	},
 }
 
@@ -70,12 +318,20 @@ var startMenu = Menu{
 			"Start chatting",
 			&eventCreateServer,
 		},
+		{
+			"Profile",
+			&eventEditProfile,
+		},
+		{
+			"Settings",
+			&eventEditSettings,
+		},
 		{
 			"Exit",
 			&eventDestroy,
 		},
 	},
-	len: 2,
+	len: 4,
 }
 
 var serverMenu = Menu{
@@ -84,10 +340,90 @@ var serverMenu = Menu{
 			"New chat",
 			&eventCreateChat,
 		},
+		{
+			"New group chat",
+			&eventCreateGroup,
+		},
+		{
+			"Contacts",
+			&eventOpenContacts,
+		},
+		{
+			"Search messages",
+			&eventSearch,
+		},
+		{
+			"Profile",
+			&eventEditProfile,
+		},
+		{
+			"Settings",
+			&eventEditSettings,
+		},
 		{
 			"Stop chating",
 			&eventBack,
 		},
 	},
+	len: 7,
+}
+
+var contactsMenu = Menu{
+	items: []MenuItem{
+		{
+			"Add contact",
+			&eventAddContact,
+		},
+		{
+			"Back",
+			&eventBack,
+		},
+	},
 	len: 2,
 }
+
+var contactMenu = Menu{
+	items: []MenuItem{
+		{
+			"Start chat",
+			&eventContactChat,
+		},
+		{
+			"Edit",
+			&eventEditContact,
+		},
+		{
+			"Delete",
+			&eventDeleteContact,
+		},
+		{
+			"Back",
+			&eventBack,
+		},
+	},
+	len: 4,
+}
+
+// messageMenu is opened by a click on a message. Delete is left out for
+// messages of other members.
+var messageMenu = Menu{
+	items: []MenuItem{
+		{
+			"Copy",
+			&eventCopyMessage,
+		},
+		{
+			"Reply",
+			&eventReply,
+		},
+		{
+			"React",
+			&eventReactPicker,
+		},
+		{
+			"Delete",
+			&eventDeleteMessage,
+		},
+	},
+	len: 4,
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const contactsFile = "contacts.json"
+
+type Contact struct {
+	Nickname  string
+	Notes     string
+	Addresses []string
+	Keys      []string
+	Favourite bool
+}
+
+func (c *Contact) HasAddress(addr string) bool {
+	for _, a := range c.Addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Contact) Label() string {
+	if c.Favourite {
+		return "★ " + c.Nickname
+	}
+	return c.Nickname
+}
+
+type Contacts struct {
+	list   []*Contact
+	active int
+}
+
+func LoadContacts() (*Contacts, error) {
+	cs := Contacts{}
+	err := loadJSON(contactsFile, &cs.list)
+	if err != nil {
+		return nil, err
+	}
+	cs.sort()
+	return &cs, nil
+}
+
+func (cs *Contacts) Save() error {
+	cs.sort()
+	return saveJSON(contactsFile, cs.list)
+}
+
+func (cs *Contacts) GetActive() *Contact {
+	if cs.active < 0 || cs.active >= len(cs.list) {
+		return nil
+	}
+	return cs.list[cs.active]
+}
+
+func (cs *Contacts) Add(c *Contact) error {
+	cs.list = append(cs.list, c)
+	return cs.Save()
+}
+
+func (cs *Contacts) Delete(c *Contact) error {
+	for i, item := range cs.list {
+		if item == c {
+			cs.list = append(cs.list[:i], cs.list[i+1:]...)
+			break
+		}
+	}
+	return cs.Save()
+}
+
+func (cs *Contacts) FindByAddress(addr string) *Contact {
+	for _, c := range cs.list {
+		if c.HasAddress(addr) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (cs *Contacts) FindByNickname(nickname string) *Contact {
+	for _, c := range cs.list {
+		if strings.EqualFold(c.Nickname, nickname) {
+			return c
+		}
+	}
+	return nil
+}
+
+// ResolveAddress turns a contact nickname into its first known address.
+// Anything else is taken as an address.
+func (cs *Contacts) ResolveAddress(s string) string {
+	if c := cs.FindByNickname(s); c != nil && len(c.Addresses) > 0 {
+		return c.Addresses[0]
+	}
+	return s
+}
+
+// Favourites go first, the rest is ordered by nickname.
+func (cs *Contacts) sort() {
+	sort.SliceStable(cs.list, func(i, j int) bool {
+		a, b := cs.list[i], cs.list[j]
+		if a.Favourite != b.Favourite {
+			return a.Favourite
+		}
+		return strings.ToLower(a.Nickname) < strings.ToLower(b.Nickname)
+	})
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseYesNo(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "y", "yes", "true", "1", "+":
+		return true
+	}
+	return false
+}
+
+func formatYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
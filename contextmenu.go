@@ -0,0 +1,67 @@
+package main
+
+// ContextMenu is a small menu drawn over the screen where it was opened.
+type ContextMenu struct {
+	ui   *UI
+	x, y int
+	Menu
+}
+
+func NewContextMenu(ui *UI, m Menu, x, y int) *ContextMenu {
+	cm := ContextMenu{
+		ui:   ui,
+		x:    x,
+		y:    y,
+		Menu: m,
+	}
+	return &cm
+}
+
+// rect keeps the menu inside the screen.
+func (cm *ContextMenu) rect() (int, int, int, int) {
+	width := 0
+	for _, item := range cm.items {
+		if len(item.label) > width {
+			width = len(item.label)
+		}
+	}
+	width += 4
+	height := len(cm.items)
+	w, h := cm.ui.tcs.Size()
+	x, y := cm.x, cm.y
+	if x+width > w {
+		x = w - width
+	}
+	if y+height > h {
+		y = h - height
+	}
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	return x, y, width, height
+}
+
+// Click runs the item under the click, a click outside the menu closes it.
+func (cm *ContextMenu) Click(cx, cy int) *Event {
+	x, y, width, height := cm.rect()
+	if cx < x || cx >= x+width || cy < y || cy >= y+height {
+		return &eventBack
+	}
+	cm.active = cy - y
+	return &eventMenuSelect
+}
+
+func (cm *ContextMenu) Draw() {
+	x, y, width, _ := cm.rect()
+	for i, item := range cm.items {
+		style := titleStyle
+		if i == cm.active {
+			style = menuActiveItemStyle
+		}
+		cm.ui.FillRect(x, y+i, width, 1, style)
+		cm.ui.DrawCells(x+2, y+i, item.label, style)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+)
+
+const killRingSize = 16
+
+// Editor is the line editor behind the input field. The text is kept as a
+// list of grapheme clusters so that the cursor never ends up inside an
+// emoji or a letter with accents.
+type Editor struct {
+	clusters []string
+	cursor   int
+	killRing []string
+	// consecutive kills are collected into a single ring entry
+	killing bool
+	// the text inserted by the last yank, so that it can be replaced
+	yankStart int
+	yankLen   int
+	yankIndex int
+	yanking   bool
+}
+
+func (e *Editor) Text() string {
+	return strings.Join(e.clusters, "")
+}
+
+// SetText replaces the text and puts the cursor at the end.
+func (e *Editor) SetText(t string) {
+	e.clusters = splitClusters(t)
+	e.cursor = len(e.clusters)
+	e.reset()
+}
+
+// CursorPosition returns the line the cursor is on and the display width
+// of the text before the cursor on that line.
+func (e *Editor) CursorPosition() (int, int) {
+	line, w := 0, 0
+	for _, c := range e.clusters[:e.cursor] {
+		if isNewline(c) {
+			line++
+			w = 0
+			continue
+		}
+		w += clusterWidth([]rune(c))
+	}
+	return line, w
+}
+
+// CursorCell returns the line the cursor is on and the number of grapheme
+// clusters before the cursor on that line, which are the cells the line is
+// laid out in.
+func (e *Editor) CursorCell() (int, int) {
+	line, col := 0, 0
+	for _, c := range e.clusters[:e.cursor] {
+		if isNewline(c) {
+			line++
+			col = 0
+			continue
+		}
+		col++
+	}
+	return line, col
+}
+
+// CursorFromEnd counts the runes after the cursor.
+func (e *Editor) CursorFromEnd() int {
+	n := 0
+	for _, c := range e.clusters[e.cursor:] {
+		n += len([]rune(c))
+	}
+	return n
+}
+
+func (e *Editor) Insert(s string) {
+	e.insert(s)
+	e.reset()
+}
+
+func (e *Editor) insert(s string) int {
+	from := e.cursor
+	e.splice(strings.Join(e.clusters[:e.cursor], "")+s, strings.Join(e.clusters[e.cursor:], ""))
+	return e.cursor - from
+}
+
+func (e *Editor) Backspace() {
+	if e.cursor > 0 {
+		e.remove(e.cursor-1, e.cursor)
+	}
+	e.reset()
+}
+
+func (e *Editor) Delete() {
+	if e.cursor < len(e.clusters) {
+		e.remove(e.cursor, e.cursor+1)
+	}
+	e.reset()
+}
+
+func (e *Editor) Left() {
+	if e.cursor > 0 {
+		e.cursor--
+	}
+	e.reset()
+}
+
+func (e *Editor) Right() {
+	if e.cursor < len(e.clusters) {
+		e.cursor++
+	}
+	e.reset()
+}
+
+func (e *Editor) Home() {
+	e.cursor = 0
+	e.reset()
+}
+
+func (e *Editor) End() {
+	e.cursor = len(e.clusters)
+	e.reset()
+}
+
+// LineUp moves the cursor to the line above and reports whether there was
+// one.
+func (e *Editor) LineUp() bool {
+	line, w := e.CursorPosition()
+	if line == 0 {
+		return false
+	}
+	e.moveTo(e.lineStarts()[line-1], w)
+	return true
+}
+
+// LineDown moves the cursor to the line below and reports whether there
+// was one.
+func (e *Editor) LineDown() bool {
+	line, w := e.CursorPosition()
+	starts := e.lineStarts()
+	if line+1 >= len(starts) {
+		return false
+	}
+	e.moveTo(starts[line+1], w)
+	return true
+}
+
+// moveTo puts the cursor on the line starting at the cluster i, as close
+// to the column w as the line allows.
+func (e *Editor) moveTo(i, w int) {
+	col := 0
+	for i < len(e.clusters) && !isNewline(e.clusters[i]) {
+		cw := clusterWidth([]rune(e.clusters[i]))
+		if col+cw > w {
+			break
+		}
+		col += cw
+		i++
+	}
+	e.cursor = i
+	e.reset()
+}
+
+func (e *Editor) lineStarts() []int {
+	starts := []int{0}
+	for i, c := range e.clusters {
+		if isNewline(c) {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+func (e *Editor) WordLeft() {
+	e.cursor = e.wordStart()
+	e.reset()
+}
+
+func (e *Editor) WordRight() {
+	i := e.cursor
+	for i < len(e.clusters) && !isWordCluster(e.clusters[i]) {
+		i++
+	}
+	for i < len(e.clusters) && isWordCluster(e.clusters[i]) {
+		i++
+	}
+	e.cursor = i
+	e.reset()
+}
+
+// KillWord cuts the word before the cursor (Ctrl+W).
+func (e *Editor) KillWord() {
+	e.kill(e.wordStart(), e.cursor, true)
+}
+
+// KillToStart cuts everything before the cursor (Ctrl+U).
+func (e *Editor) KillToStart() {
+	e.kill(0, e.cursor, true)
+}
+
+// KillToEnd cuts everything after the cursor (Ctrl+K).
+func (e *Editor) KillToEnd() {
+	e.kill(e.cursor, len(e.clusters), false)
+}
+
+// Yank pastes the last killed text (Ctrl+Y).
+func (e *Editor) Yank() {
+	if len(e.killRing) == 0 {
+		return
+	}
+	e.killing = false
+	e.yankIndex = len(e.killRing) - 1
+	e.yankStart = e.cursor
+	e.yankLen = e.insert(e.killRing[e.yankIndex])
+	e.yanking = true
+}
+
+// YankPop replaces the text just yanked with the previous kill (Alt+Y).
+func (e *Editor) YankPop() {
+	if !e.yanking || len(e.killRing) < 2 {
+		return
+	}
+	e.remove(e.yankStart, e.yankStart+e.yankLen)
+	e.yankIndex--
+	if e.yankIndex < 0 {
+		e.yankIndex = len(e.killRing) - 1
+	}
+	e.yankLen = e.insert(e.killRing[e.yankIndex])
+}
+
+func (e *Editor) kill(from, to int, backward bool) {
+	if from == to {
+		return
+	}
+	text := strings.Join(e.clusters[from:to], "")
+	e.remove(from, to)
+	e.yanking = false
+	if e.killing && len(e.killRing) > 0 {
+		last := len(e.killRing) - 1
+		if backward {
+			e.killRing[last] = text + e.killRing[last]
+		} else {
+			e.killRing[last] += text
+		}
+		return
+	}
+	e.killing = true
+	e.killRing = append(e.killRing, text)
+	if len(e.killRing) > killRingSize {
+		e.killRing = e.killRing[1:]
+	}
+}
+
+func (e *Editor) remove(from, to int) {
+	e.splice(strings.Join(e.clusters[:from], ""), strings.Join(e.clusters[to:], ""))
+}
+
+// splice sets the text to before followed by after with the cursor between
+// them. The whole text is split into clusters again, because a joiner, a
+// variation selector or a second flag letter merges with the cluster next
+// to it. The cursor then goes after the merged cluster.
+func (e *Editor) splice(before, after string) {
+	e.clusters = splitClusters(before + after)
+	e.cursor = 0
+	for n := 0; n < len(before) && e.cursor < len(e.clusters); e.cursor++ {
+		n += len(e.clusters[e.cursor])
+	}
+}
+
+func (e *Editor) reset() {
+	e.killing = false
+	e.yanking = false
+}
+
+func (e *Editor) wordStart() int {
+	i := e.cursor
+	for i > 0 && !isWordCluster(e.clusters[i-1]) {
+		i--
+	}
+	for i > 0 && isWordCluster(e.clusters[i-1]) {
+		i--
+	}
+	return i
+}
+
+func isWordCluster(c string) bool {
+	for _, r := range c {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+	return false
+}
+
+func isNewline(c string) bool {
+	return c == "\n" || c == "\r\n"
+}
+
+func splitClusters(t string) []string {
+	var clusters []string
+	g := uniseg.NewGraphemes(t)
+	for g.Next() {
+		clusters = append(clusters, g.Str())
+	}
+	return clusters
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Input methods and pastes can deliver the runes of a cluster one by one,
+// the editor has to join them like the layout does.
+func TestEditorInsertRunes(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		clusters []string
+	}{
+		{"zwj emoji", "👩‍💻", []string{"👩‍💻"}},
+		{"flag", "🇩🇪", []string{"🇩🇪"}},
+		{"two flags", "🇩🇪🇫🇷", []string{"🇩🇪", "🇫🇷"}},
+		{"skin tone", "👍🏽!", []string{"👍🏽", "!"}},
+		{"variation selector", "❤️", []string{"❤️"}},
+		{"combining accent", "éx", []string{"é", "x"}},
+	}
+	for _, tt := range tests {
+		e := Editor{}
+		for _, r := range tt.text {
+			e.Insert(string(r))
+		}
+		if !reflect.DeepEqual(e.clusters, tt.clusters) {
+			t.Errorf("%s: clusters %q, want %q", tt.name, e.clusters, tt.clusters)
+		}
+		if e.cursor != len(tt.clusters) {
+			t.Errorf("%s: cursor %d, want %d", tt.name, e.cursor, len(tt.clusters))
+		}
+		if cells := layoutCells(e.Text()); len(cells) != len(e.clusters) {
+			t.Errorf("%s: %d clusters but %d cells", tt.name, len(e.clusters), len(cells))
+		}
+	}
+}
+
+func TestEditorJoinAroundCursor(t *testing.T) {
+	e := Editor{}
+	e.SetText("ab")
+	e.Left()
+	e.Insert("́")
+	if want := []string{"á", "b"}; !reflect.DeepEqual(e.clusters, want) || e.cursor != 1 {
+		t.Errorf("clusters %q cursor %d, want %q cursor 1", e.clusters, e.cursor, want)
+	}
+	// Deleting the letter between two flag letters joins them into a flag
+	e.SetText("🇩x🇪")
+	e.Left()
+	e.Backspace()
+	if want := []string{"🇩🇪"}; !reflect.DeepEqual(e.clusters, want) || e.cursor != 1 {
+		t.Errorf("clusters %q cursor %d, want %q cursor 1", e.clusters, e.cursor, want)
+	}
+}
+
+// editorAt is an editor with the text, the cursor where the "|" is.
+func editorAt(s string) *Editor {
+	i := strings.Index(s, "|")
+	e := &Editor{}
+	e.SetText(s[:i] + s[i+1:])
+	e.cursor = len(splitClusters(s[:i]))
+	return e
+}
+
+func withCursor(e *Editor) string {
+	return strings.Join(e.clusters[:e.cursor], "") + "|" + strings.Join(e.clusters[e.cursor:], "")
+}
+
+func TestEditorWords(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		edit func(e *Editor)
+		want string
+	}{
+		{"word left", "foo bar|", (*Editor).WordLeft, "foo |bar"},
+		{"word left inside a word", "foo ba|r", (*Editor).WordLeft, "foo |bar"},
+		{"word left over spaces", "foo bar  |", (*Editor).WordLeft, "foo |bar  "},
+		{"word left over punctuation", "foo, |bar", (*Editor).WordLeft, "|foo, bar"},
+		{"word left keeps snake_case", "a snake_case|", (*Editor).WordLeft, "a |snake_case"},
+		{"word left at the start", "|foo", (*Editor).WordLeft, "|foo"},
+		{"word left over a line", "foo\n|bar", (*Editor).WordLeft, "|foo\nbar"},
+		{"word right", "|foo bar", (*Editor).WordRight, "foo| bar"},
+		{"word right over spaces", "foo| bar", (*Editor).WordRight, "foo bar|"},
+		{"word right at the end", "foo|", (*Editor).WordRight, "foo|"},
+		{"word right over letters", "|été café", (*Editor).WordRight, "été| café"},
+		{"kill word", "foo bar|", (*Editor).KillWord, "foo |"},
+		{"kill word inside a word", "foo ba|r", (*Editor).KillWord, "foo |r"},
+		{"kill word with spaces", "foo bar  |baz", (*Editor).KillWord, "foo |baz"},
+		{"kill to start", "foo b|ar", (*Editor).KillToStart, "|ar"},
+		{"kill to end", "foo b|ar", (*Editor).KillToEnd, "foo b|"},
+	}
+	for _, tt := range tests {
+		e := editorAt(tt.text)
+		tt.edit(e)
+		if got := withCursor(e); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditorKillRing(t *testing.T) {
+	e := editorAt("one two three|")
+	// Kills in a row go into one entry, in the order of the text
+	e.KillWord()
+	e.KillWord()
+	e.Yank()
+	if got, want := withCursor(e), "one two three|"; got != want {
+		t.Errorf("yank after two kills: %q, want %q", got, want)
+	}
+
+	e = editorAt("a| b")
+	e.KillToEnd()
+	e.Insert("x")
+	// Typing in between starts a new entry
+	e.KillToStart()
+	if got, want := e.killRing, []string{" b", "ax"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kill ring %q, want %q", got, want)
+	}
+	e.Yank()
+	if got, want := withCursor(e), "ax|"; got != want {
+		t.Errorf("yank: %q, want %q", got, want)
+	}
+	e.YankPop()
+	if got, want := withCursor(e), " b|"; got != want {
+		t.Errorf("yank pop: %q, want %q", got, want)
+	}
+	// The cycle goes back to the newest kill
+	e.YankPop()
+	if got, want := withCursor(e), "ax|"; got != want {
+		t.Errorf("yank pop around the ring: %q, want %q", got, want)
+	}
+	// Anything else in between ends the yank
+	e.Left()
+	e.YankPop()
+	if got, want := withCursor(e), "a|x"; got != want {
+		t.Errorf("yank pop after a move: %q, want %q", got, want)
+	}
+}
+
+func TestEditorKillRingSize(t *testing.T) {
+	e := &Editor{}
+	for i := 0; i < killRingSize+3; i++ {
+		e.SetText(fmt.Sprint("kill", i))
+		e.KillToStart()
+	}
+	if len(e.killRing) != killRingSize {
+		t.Fatalf("kill ring has %d entries, want %d", len(e.killRing), killRingSize)
+	}
+	if got, want := e.killRing[0], "kill3"; got != want {
+		t.Errorf("oldest kill %q, want %q", got, want)
+	}
+	e.Yank()
+	if got, want := e.Text(), fmt.Sprint("kill", killRingSize+2); got != want {
+		t.Errorf("yank %q, want %q", got, want)
+	}
+}
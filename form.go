@@ -0,0 +1,80 @@
+package main
+
+type FormField struct {
+	label string
+	value string
+}
+
+type FormScreen struct {
+	ui     *UI
+	title  string
+	fields []FormField
+	active int
+	submit *Event
+	err    string
+}
+
+func NewFormScreen(ui *UI, title string, fields []FormField, submit *Event) *FormScreen {
+	fs := FormScreen{
+		ui:     ui,
+		title:  title,
+		fields: fields,
+		submit: submit,
+	}
+	return &fs
+}
+
+func (fs *FormScreen) InitialInput() string {
+	return fs.fields[fs.active].value
+}
+
+func (fs *FormScreen) Value(i int) string {
+	if i == fs.active {
+		return fs.ui.typed
+	}
+	return fs.fields[i].value
+}
+
+func (fs *FormScreen) SetError(err string) {
+	fs.err = err
+}
+
+func (fs *FormScreen) MenuUp() {
+	fs.fields[fs.active].value = fs.ui.typed
+	fs.active--
+	if fs.active < 0 {
+		fs.active = len(fs.fields) - 1
+	}
+	fs.ui.SetTyped(fs.fields[fs.active].value)
+}
+
+func (fs *FormScreen) MenuDown() {
+	fs.fields[fs.active].value = fs.ui.typed
+	fs.active++
+	if fs.active >= len(fs.fields) {
+		fs.active = 0
+	}
+	fs.ui.SetTyped(fs.fields[fs.active].value)
+}
+
+func (fs *FormScreen) GetMenuEvent() *Event {
+	fs.fields[fs.active].value = fs.ui.typed
+	return fs.submit
+}
+
+func (fs *FormScreen) Draw() {
+	fs.ui.DrawText(fs.title, titleStyle, false)
+	for i, f := range fs.fields {
+		if i == fs.active {
+			fs.ui.DrawText(f.label, menuActiveItemStyle, false)
+			fs.ui.DrawText(fs.ui.typed, inputStyle, true)
+		} else {
+			fs.ui.DrawText(f.label, menuItemStyle, false)
+			fs.ui.DrawText(f.value, menuItemStyle, false)
+		}
+	}
+	fs.ui.DrawTextBottom("Up/Down: switch field, Enter: save, Esc: cancel", footerStyle, false)
+	if len(fs.err) > 0 {
+		fs.ui.DrawTextBottom(fs.err, footerStyle, false)
+	}
+}
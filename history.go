@@ -0,0 +1,135 @@
+package main
+
+import "strings"
+
+const (
+	historyFile  = "history.json"
+	historyLimit = 500
+)
+
+// InputHistory keeps the lines sent from the chat input, for every chat
+// and all chats together.
+type InputHistory struct {
+	Chats map[string][]string
+	All   []string
+}
+
+func LoadInputHistory() (*InputHistory, error) {
+	h := InputHistory{}
+	if err := loadJSON(historyFile, &h); err != nil {
+		return nil, err
+	}
+	if h.Chats == nil {
+		h.Chats = make(map[string][]string)
+	}
+	return &h, nil
+}
+
+// Add records a sent line. Repeating the previous line doesn't add it again.
+func (h *InputHistory) Add(key, line string) error {
+	if len(strings.TrimSpace(line)) == 0 {
+		return nil
+	}
+	h.Chats[key] = appendLine(h.Chats[key], line)
+	h.All = appendLine(h.All, line)
+	return saveJSON(historyFile, h)
+}
+
+// Lines returns the history of the chat, oldest first.
+func (h *InputHistory) Lines(key string, shared bool) []string {
+	if shared {
+		return h.All
+	}
+	return h.Chats[key]
+}
+
+func appendLine(lines []string, line string) []string {
+	if len(lines) > 0 && lines[len(lines)-1] == line {
+		return lines
+	}
+	lines = append(lines, line)
+	if len(lines) > historyLimit {
+		lines = lines[len(lines)-historyLimit:]
+	}
+	return lines
+}
+
+// historyKey identifies the chat in the history between sessions.
+func (c *Chat) historyKey() string {
+	if c.IsGroup() {
+		return "group:" + c.id
+	}
+	if len(c.members) == 0 {
+		return ""
+	}
+	return c.members[0].peer.address
+}
+
+// Recall walks through the history from the newest line like a shell
+// does. The text typed before the walk started comes back after the newest
+// line.
+type Recall struct {
+	lines []string
+	pos   int
+	draft string
+	// reverse search
+	query string
+	match int
+}
+
+func NewRecall(lines []string, draft string) *Recall {
+	return &Recall{
+		lines: lines,
+		pos:   len(lines),
+		draft: draft,
+		match: len(lines),
+	}
+}
+
+func (r *Recall) Prev() (string, bool) {
+	if r.pos == 0 {
+		return "", false
+	}
+	r.pos--
+	return r.lines[r.pos], true
+}
+
+func (r *Recall) Next() (string, bool) {
+	if r.pos >= len(r.lines) {
+		return "", false
+	}
+	r.pos++
+	if r.pos == len(r.lines) {
+		return r.draft, true
+	}
+	return r.lines[r.pos], true
+}
+
+// Search finds the newest line containing the query. When the query is
+// the same as the last time the search goes on to older lines.
+func (r *Recall) Search(query string) (string, bool) {
+	if query != r.query {
+		r.query = query
+		r.match = len(r.lines)
+	}
+	if len(query) == 0 {
+		return "", false
+	}
+	q := strings.ToLower(query)
+	for i := r.match - 1; i >= 0; i-- {
+		if strings.Contains(strings.ToLower(r.lines[i]), q) {
+			r.match = i
+			r.pos = i
+			return r.lines[i], true
+		}
+	}
+	return "", false
+}
+
+// Match is the line found by the last search.
+func (r *Recall) Match() (string, bool) {
+	if r.match >= len(r.lines) {
+		return "", false
+	}
+	return r.lines[r.match], true
+}
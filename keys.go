@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const keysFile = "keys.conf"
+
+// unbindAction removes a default binding in the keys file.
+const unbindAction = "none"
+
+// Section names of the states in the keys file.
+var appStateNames = map[AppState]string{
+	appStateStarting:    "start",
+	appStateServer:      "server",
+	appStateNewChat:     "new-chat",
+	appStateChat:        "chat",
+	appStateContacts:    "contacts",
+	appStateContact:     "contact",
+	appStateForm:        "form",
+	appStateHistory:     "revisions",
+	appStateSelect:      "select",
+	appStateReact:       "react",
+	appStateRecall:      "history-search",
+	appStateConfirmSend: "confirm-send",
+	appStateContextMenu: "context-menu",
+	appStateSidebar:     "chat-list",
+	appStateLinks:       "links",
+	appStateSearch:      "search",
+}
+
+// States where the keys go to the input first, so single characters bound
+// there would never fire.
+var typingStates = map[AppState]bool{
+	appStateNewChat: true,
+	appStateChat:    true,
+	appStateForm:    true,
+	appStateRecall:  true,
+	appStateSearch:  true,
+}
+
+// Actions the keys can be bound to.
+var keyActions = map[string]*Event{
+	"quit":           &eventDestroy,
+	"back":           &eventBack,
+	"menu-up":        &eventMenuUp,
+	"menu-down":      &eventMenuDown,
+	"menu-select":    &eventMenuSelect,
+	"start":          &eventCreateServer,
+	"new-chat":       &eventCreateChat,
+	"new-group":      &eventCreateGroup,
+	"connect":        &eventConnectServer,
+	"contacts":       &eventOpenContacts,
+	"profile":        &eventEditProfile,
+	"settings":       &eventEditSettings,
+	"send-message":   &eventSendMessage,
+	"edit-message":   &eventEditMessage,
+	"revisions":      &eventShowHistory,
+	"delete-message": &eventDeleteMessage,
+	"undo-send":      &eventUndoSend,
+	"select-mode":    &eventSelectMode,
+	"reply":          &eventReply,
+	"threaded-view":  &eventThreadedView,
+	"toggle-thread":  &eventToggleThread,
+	"react-picker":   &eventReactPicker,
+	"react":          &eventReact,
+	"picker-up":      &eventPickerUp,
+	"picker-down":    &eventPickerDown,
+	"picker-left":    &eventPickerLeft,
+	"picker-right":   &eventPickerRight,
+	"pause-preview":  &eventPausePreview,
+	"preview-mode":   &eventPreviewMode,
+	"history-prev":   &eventHistoryPrev,
+	"history-next":   &eventHistoryNext,
+	"search-history": &eventSearchHistory,
+	"accept-history": &eventAcceptRecall,
+	"copy-message":   &eventCopyMessage,
+	"focus-next":     &eventFocusNext,
+	"raw-text":       &eventToggleRaw,
+	"links":          &eventShowLinks,
+	"search":         &eventSearch,
+	"complete":       &eventComplete,
+}
+
+// LoadKeyBindings returns the default bindings of every state with the
+// ones from the keys file applied on top of them.
+func LoadKeyBindings() (map[AppState]KeyEventMap, error) {
+	bindings := make(map[AppState]KeyEventMap)
+	for state, m := range stateEventMap {
+		bindings[state] = make(KeyEventMap)
+		for k, e := range m {
+			seq, err := normalizeKeys(k)
+			if err != nil {
+				return nil, err
+			}
+			bindings[state][seq] = e
+		}
+	}
+	path, err := storagePath(keysFile)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return bindings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err = parseKeyBindings(f.Name(), bufio.NewScanner(f), bindings); err != nil {
+		return nil, err
+	}
+	if err = checkKeyBindings(bindings); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	return bindings, nil
+}
+
+// parseKeyBindings reads lines like "ctrl+n = new-chat" grouped under
+// "[state]" headers. Keys of a chord are separated by spaces.
+func parseKeyBindings(name string, s *bufio.Scanner, bindings map[AppState]KeyEventMap) error {
+	states := make(map[string]AppState)
+	for state, n := range appStateNames {
+		states[n] = state
+	}
+	var state AppState
+	section := ""
+	// where each key was bound in the file, to report duplicates
+	bound := make(map[string]int)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(s.Text()))
+		if len(text) == 0 {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			var ok bool
+			if state, ok = states[section]; !ok {
+				return fmt.Errorf("%s:%d: unknown section [%s]", name, line, section)
+			}
+			continue
+		}
+		if len(section) == 0 {
+			return fmt.Errorf("%s:%d: binding outside of a [state] section", name, line)
+		}
+		// The action never has a "=", the keys may
+		i := strings.LastIndex(text, "=")
+		if i < 0 {
+			return fmt.Errorf("%s:%d: expected \"keys = action\"", name, line)
+		}
+		parts := []string{text[:i], text[i+1:]}
+		seq, err := normalizeKeys(parts[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		action := strings.TrimSpace(parts[1])
+		where := section + " " + seq
+		if prev, ok := bound[where]; ok {
+			return fmt.Errorf("%s:%d: %s is already bound in [%s] on line %d", name, line, seq, section, prev)
+		}
+		bound[where] = line
+		if action == unbindAction {
+			delete(bindings[state], seq)
+			continue
+		}
+		e, ok := keyActions[action]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown action %q", name, line, action)
+		}
+		if bindings[state] == nil {
+			bindings[state] = make(KeyEventMap)
+		}
+		bindings[state][seq] = InputEvent{event: e}
+	}
+	return s.Err()
+}
+
+// stripComment cuts off a comment, which starts with a "#" at the start of
+// the line or after a space. A "#" followed by "=" is the key itself, as in
+// "# = links".
+func stripComment(line string) string {
+	for i, r := range line {
+		if r != '#' || i > 0 && !unicode.IsSpace(rune(line[i-1])) {
+			continue
+		}
+		if !strings.HasPrefix(strings.TrimLeft(line[i+1:], " \t"), "=") {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// checkKeyBindings reports bindings of a state that can't all work: a
+// chord starting with a key that is bound on its own, or a single
+// character bound where it is typed into the input.
+func checkKeyBindings(bindings map[AppState]KeyEventMap) error {
+	var problems []string
+	for state, m := range bindings {
+		for seq := range m {
+			keys := strings.Fields(seq)
+			for i := 1; i < len(keys); i++ {
+				prefix := strings.Join(keys[:i], " ")
+				if _, ok := m[prefix]; ok {
+					problems = append(problems, fmt.Sprintf("[%s] %s hides the chord %s", appStateNames[state], prefix, seq))
+				}
+			}
+			if typingStates[state] && len([]rune(keys[0])) == 1 {
+				problems = append(problems, fmt.Sprintf("[%s] %s is typed into the input and can't be bound", appStateNames[state], keys[0]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("key binding conflicts: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Modifiers in the order they are written in key names.
+var keyModifiers = []string{"ctrl", "alt", "shift"}
+
+// normalizeKeys brings a chord to the form keyName returns, so that
+// "Ctrl+X  ctrl+s" becomes "ctrl+x ctrl+s".
+func normalizeKeys(seq string) (string, error) {
+	keys := strings.Fields(seq)
+	if len(keys) == 0 {
+		return "", errors.New("no keys")
+	}
+	for i, k := range keys {
+		parts := strings.Split(k, "+")
+		// "+" itself, or a modified "+" like "alt++"
+		if k == "+" || strings.HasSuffix(k, "++") {
+			parts = append(parts[:len(parts)-2], "+")
+		}
+		mods := make(map[string]bool)
+		for _, m := range parts[:len(parts)-1] {
+			m = strings.ToLower(m)
+			if !isKeyModifier(m) {
+				return "", fmt.Errorf("unknown modifier %q in %s", m, k)
+			}
+			mods[m] = true
+		}
+		name := parts[len(parts)-1]
+		if len([]rune(name)) > 1 || mods["ctrl"] {
+			name = strings.ToLower(name)
+		} else if mods["shift"] {
+			// Shift is already in the character
+			name = strings.ToUpper(name)
+			delete(mods, "shift")
+		}
+		if len(name) == 0 {
+			return "", fmt.Errorf("no key in %s", k)
+		}
+		for j := len(keyModifiers) - 1; j >= 0; j-- {
+			if mods[keyModifiers[j]] {
+				name = keyModifiers[j] + "+" + name
+			}
+		}
+		keys[i] = name
+	}
+	return strings.Join(keys, " "), nil
+}
+
+func isKeyModifier(m string) bool {
+	for _, km := range keyModifiers {
+		if m == km {
+			return true
+		}
+	}
+	return false
+}
+
+// keyName names a key press the way bindings are written.
+func keyName(ev *tcell.EventKey) string {
+	m := ev.Modifiers()
+	name := ""
+	if ev.Key() == tcell.KeyRune {
+		name = string(ev.Rune())
+		// Shift is already in the character
+		m &^= tcell.ModShift
+	} else {
+		n, ok := tcell.KeyNames[ev.Key()]
+		if !ok {
+			return ""
+		}
+		name = strings.ToLower(strings.Replace(n, "Ctrl-", "Ctrl+", 1))
+		if strings.HasPrefix(name, "ctrl+") {
+			name = strings.TrimPrefix(name, "ctrl+")
+			m |= tcell.ModCtrl
+		}
+	}
+	if m&tcell.ModShift != 0 {
+		name = "shift+" + name
+	}
+	if m&tcell.ModAlt != 0 {
+		name = "alt+" + name
+	}
+	if m&tcell.ModCtrl != 0 {
+		name = "ctrl+" + name
+	}
+	return name
+}
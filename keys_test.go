@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestNormalizeKeys(t *testing.T) {
+	tests := []struct {
+		keys string
+		want string
+		err  bool
+	}{
+		{"Ctrl+N", "ctrl+n", false},
+		{"Ctrl+X  ctrl+s", "ctrl+x ctrl+s", false},
+		{"shift+ctrl+alt+X", "ctrl+alt+shift+x", false},
+		{"Shift+a", "A", false},
+		{"shift+Tab", "shift+tab", false},
+		{"Enter", "enter", false},
+		{"alt+é", "alt+é", false},
+		{"+", "+", false},
+		{"alt++", "alt++", false},
+		{"ctrl+x +", "ctrl+x +", false},
+		{"", "", true},
+		{"hyper+x", "", true},
+		{"ctrl+", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeKeys(tt.keys)
+		if (err != nil) != tt.err {
+			t.Errorf("normalizeKeys(%q) error = %v", tt.keys, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeKeys(%q) = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
+
+// Key presses have to get the names the bindings are normalized to.
+func TestKeyName(t *testing.T) {
+	tests := []struct {
+		ev      *tcell.EventKey
+		binding string
+	}{
+		{tcell.NewEventKey(tcell.KeyCtrlN, 0, tcell.ModCtrl), "Ctrl+N"},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), "Enter"},
+		{tcell.NewEventKey(tcell.KeyF3, 0, tcell.ModNone), "F3"},
+		{tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModShift), "Shift+Up"},
+		{tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModAlt), "Alt+x"},
+		{tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModShift), "Shift+x"},
+		{tcell.NewEventKey(tcell.KeyRune, '+', tcell.ModAlt), "alt++"},
+	}
+	for _, tt := range tests {
+		want, err := normalizeKeys(tt.binding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keyName(tt.ev); got != want {
+			t.Errorf("keyName(%s) = %q, want %q for %q", tt.ev.Name(), got, want, tt.binding)
+		}
+	}
+}
+
+func TestParseKeyBindings(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		// error text, empty when the file is fine
+		err string
+	}{
+		{"bindings", "# comment\n[chat]\nctrl+x ctrl+s = select-mode # chord\n[server]\nctrl+n = new-chat\n", ""},
+		{"unbind", "[chat]\nctrl+s = none\n", ""},
+		{"duplicate", "[chat]\nctrl+x = select-mode\nCtrl+X = links\n", "keys:3: ctrl+x is already bound in [chat] on line 2"},
+		{"unknown action", "[chat]\nctrl+x = fly\n", `keys:2: unknown action "fly"`},
+		{"unknown section", "[nowhere]\n", "keys:1: unknown section [nowhere]"},
+		{"outside section", "ctrl+x = links\n", "keys:1: binding outside of a [state] section"},
+		{"no action", "[chat]\nctrl+x\n", `keys:2: expected "keys = action"`},
+		{"bad modifier", "[chat]\nmeta+x = links\n", `keys:2: unknown modifier "meta" in meta+x`},
+		{"prefix hides chord", "[chat]\nctrl+x = links\nctrl+x ctrl+s = select-mode\n", "[chat] ctrl+x hides the chord ctrl+x ctrl+s"},
+		{"typed key", "[chat]\nq = links\n", "[chat] q is typed into the input and can't be bound"},
+		{"typed key in a menu", "[server]\nq = new-chat\n", ""},
+		{"bind #", "[server]\n# = new-chat\nalt+# = new-chat # comment\n", ""},
+		{"bind =", "[server]\n= = new-chat\nalt+==new-chat\n", ""},
+		{"# typed in the input", "[chat]\n# = links\n", "[chat] # is typed into the input and can't be bound"},
+		{"commented out binding", "[chat]\n# ctrl+x = fly\n#ctrl+y = fly\n", ""},
+	}
+	for _, tt := range tests {
+		bindings := map[AppState]KeyEventMap{
+			appStateChat: {"ctrl+s": InputEvent{event: &eventSelectMode}},
+		}
+		err := parseKeyBindings("keys", bufio.NewScanner(strings.NewReader(tt.file)), bindings)
+		if err == nil {
+			err = checkKeyBindings(bindings)
+		}
+		switch {
+		case err == nil && len(tt.err) > 0:
+			t.Errorf("%s: no error, want %q", tt.name, tt.err)
+		case err != nil && (len(tt.err) == 0 || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %q, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	bindings := map[AppState]KeyEventMap{
+		appStateChat: {"ctrl+s": InputEvent{event: &eventSelectMode}},
+	}
+	file := "[chat]\nctrl+s = none\nCtrl+X  Ctrl+S = select-mode\n[server]\nalt++ = new-chat\n# = new-chat # on #\n= = new-chat\n"
+	if err := parseKeyBindings("keys", bufio.NewScanner(strings.NewReader(file)), bindings); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bindings[appStateChat]["ctrl+s"]; ok {
+		t.Errorf("ctrl+s is still bound")
+	}
+	if e := bindings[appStateChat]["ctrl+x ctrl+s"].event; e != &eventSelectMode {
+		t.Errorf("ctrl+x ctrl+s is bound to %v", e)
+	}
+	for _, k := range []string{"alt++", "#", "="} {
+		if e := bindings[appStateServer][k].event; e != &eventCreateChat {
+			t.Errorf("%s is bound to %v", k, e)
+		}
+	}
+}
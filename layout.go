@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+// cell is a grapheme cluster as it is put on the screen: a letter with its
+// accents, or a whole emoji with skin tones and ZWJ-joined parts.
+type cell struct {
+	runes []rune
+	width int
+	attr  markAttr
+	// the link the cell is part of
+	link string
+}
+
+// row is the part of a text that fits on one screen row.
+type row []cell
+
+func (r row) width() int {
+	w := 0
+	for _, c := range r {
+		w += c.width
+	}
+	return w
+}
+
+// layoutCells splits the text into cells.
+func layoutCells(text string) row {
+	var cells row
+	g := uniseg.NewGraphemes(text)
+	for g.Next() {
+		runes := g.Runes()
+		w := clusterWidth(runes)
+		if w == 0 {
+			// Marks without a letter to sit on get a space
+			runes = append([]rune{' '}, runes...)
+			w = 1
+		}
+		cells = append(cells, cell{runes: runes, width: w})
+	}
+	return cells
+}
+
+// clusterWidth is the number of columns a grapheme cluster takes. The
+// first rune decides it, the rest are modifiers and joined parts drawn over
+// it, except VS16 and flags which ask for the wide emoji presentation.
+func clusterWidth(runes []rune) int {
+	if len(runes) == 0 {
+		return 0
+	}
+	for _, r := range runes[1:] {
+		if r == '\ufe0f' {
+			return 2
+		}
+	}
+	if len(runes) > 1 && isRegionalIndicator(runes[0]) {
+		return 2
+	}
+	return runewidth.RuneWidth(runes[0])
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// stringWidth is the number of columns the text takes on a single row.
+func stringWidth(s string) int {
+	return layoutCells(s).width()
+}
+
+// wrapText breaks the text into rows at most w columns wide. Lines break
+// after spaces where they can and inside words only when a word is longer
+// than a row. Every line of the text takes at least one row.
+func wrapText(text string, w int) []row {
+	var rows []row
+	for _, line := range strings.Split(text, "\n") {
+		rows = append(rows, wrapLine(layoutCells(line), w)...)
+	}
+	return rows
+}
+
+func wrapLine(cells row, w int) []row {
+	var rows []row
+	var cur row
+	curW := 0
+	// where the row can be broken, after the last space in it
+	brk := 0
+	for _, c := range cells {
+		if curW+c.width > w && len(cur) > 0 {
+			if isSpaceCell(c) {
+				// Spaces at the end of a row may stick out, they aren't drawn
+				cur = append(cur, c)
+				curW += c.width
+				brk = len(cur)
+				continue
+			}
+			next := row{}
+			if brk > 0 {
+				next = append(next, cur[brk:]...)
+				cur = cur[:brk]
+			}
+			rows = append(rows, cur)
+			cur = next
+			curW = cur.width()
+			brk = 0
+		}
+		cur = append(cur, c)
+		curW += c.width
+		if isSpaceCell(c) {
+			brk = len(cur)
+		}
+	}
+	return append(rows, cur)
+}
+
+func isSpaceCell(c cell) bool {
+	return len(c.runes) == 1 && c.runes[0] == ' '
+}
+
+// truncate cuts the text to fit n columns on a single row.
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	cells := layoutCells(s)
+	if cells.width() <= n {
+		return s
+	}
+	var b strings.Builder
+	w := 0
+	for _, c := range cells {
+		if w+c.width > n-1 {
+			break
+		}
+		b.WriteString(string(c.runes))
+		w += c.width
+	}
+	return b.String() + "…"
+}
+
+// wrapMarkup is wrapText for text with markup, see markupLines.
+func wrapMarkup(text string, w int, mode MarkupMode) []row {
+	var rows []row
+	for _, line := range markupLines(text, mode) {
+		rows = append(rows, wrapLine(line, w)...)
+	}
+	return rows
+}
+
+// textLayout remembers how a text was wrapped, so that messages aren't laid
+// out again on every redraw.
+type textLayout struct {
+	text  string
+	width int
+	mode  MarkupMode
+	rows  []row
+}
+
+// Wrap returns the rows of the text at the width, from the cache when
+// nothing has changed.
+func (l *textLayout) Wrap(text string, w int, mode MarkupMode) []row {
+	if l.rows == nil || l.text != text || l.width != w || l.mode != mode {
+		l.text = text
+		l.width = w
+		l.mode = mode
+		l.rows = wrapMarkup(text, w, mode)
+	}
+	return l.rows
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func rowStrings(rows []row) []string {
+	var lines []string
+	for _, r := range rows {
+		s := ""
+		for _, c := range r {
+			s += string(c.runes)
+		}
+		lines = append(lines, s)
+	}
+	return lines
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"", 10, []string{""}},
+		{"hello", 5, []string{"hello"}},
+		{"hello world", 11, []string{"hello world"}},
+		{"hello world next", 11, []string{"hello world ", "next"}},
+		{"hello world", 8, []string{"hello ", "world"}},
+		{"aa bb  cc", 5, []string{"aa bb  ", "cc"}},
+		{"abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"one\ntwo", 10, []string{"one", "two"}},
+		{"世界你好", 4, []string{"世界", "你好"}},
+		{"a世界", 4, []string{"a世", "界"}},
+		{"👩‍💻👩‍💻 x", 4, []string{"👩‍💻👩‍💻 ", "x"}},
+	}
+	for _, tt := range tests {
+		got := rowStrings(wrapText(tt.text, tt.width))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapText(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestLayoutCells(t *testing.T) {
+	tests := []struct {
+		text  string
+		cells int
+		width int
+	}{
+		{"abc", 3, 3},
+		{"世界", 2, 4},
+		{"é", 1, 1},
+		{"👩‍💻", 1, 2},
+		{"👍🏽", 1, 2},
+		{"❤️", 1, 2},
+		{"🇫🇷", 1, 2},
+		{"́", 1, 1},
+	}
+	for _, tt := range tests {
+		cells := layoutCells(tt.text)
+		if len(cells) != tt.cells || cells.width() != tt.width {
+			t.Errorf("layoutCells(%q) = %d cells %d wide, want %d cells %d wide",
+				tt.text, len(cells), cells.width(), tt.cells, tt.width)
+		}
+	}
+}
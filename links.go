@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'` + "`" + `]+`)
+
+// findURLs returns the byte ranges of the links in the text. Punctuation
+// at the end belongs to the sentence, not to the link.
+func findURLs(text string) [][]int {
+	found := urlPattern.FindAllStringIndex(text, -1)
+	for _, r := range found {
+		for r[1] > r[0] && strings.ContainsRune(".,;:!?)]}*_", rune(text[r[1]-1])) {
+			// keep the parenthesis of links like .../Go_(language)
+			if text[r[1]-1] == ')' && strings.Count(text[r[0]:r[1]], "(") >= strings.Count(text[r[0]:r[1]], ")") {
+				break
+			}
+			r[1]--
+		}
+	}
+	return found
+}
+
+// linkTarget adds the scheme that links starting with "www." lack.
+func linkTarget(url string) string {
+	if strings.HasPrefix(strings.ToLower(url), "www.") {
+		return "http://" + url
+	}
+	return url
+}
+
+// hyperlinksSupported guesses from the environment whether the terminal
+// understands OSC 8 hyperlinks. Terminals that don't may print the escape
+// sequences as garbage, so unknown ones get none.
+func hyperlinksSupported() bool {
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "vscode", "Hyper":
+		return true
+	}
+	for _, v := range []string{"VTE_VERSION", "KITTY_WINDOW_ID", "WT_SESSION", "KONSOLE_VERSION"} {
+		if len(os.Getenv(v)) > 0 {
+			return true
+		}
+	}
+	term := os.Getenv("TERM")
+	return strings.Contains(term, "kitty") || strings.Contains(term, "foot") || strings.Contains(term, "alacritty")
+}
+
+// linkCell is a cell of a link drawn in the frame, see hyperlinkEscapes.
+type linkCell struct {
+	x, y  int
+	c     cell
+	style tcell.Style
+}
+
+// hyperlinkEscapes writes the link cells of the frame again, this time as
+// OSC 8 hyperlinks. tcell doesn't know about them, so they go out after it
+// has shown the frame, with the cursor and the style saved and put back
+// around them. Cells something else was drawn over since are left alone.
+func (ui *UI) hyperlinkEscapes() string {
+	var b strings.Builder
+	link := ""
+	nextX, nextY := -1, -1
+	var style tcell.Style
+	for _, lc := range ui.links {
+		mainc, combc, st, _ := ui.tcs.GetContent(lc.x, lc.y)
+		if st != lc.style || string(mainc)+string(combc) != string(lc.c.runes) {
+			continue
+		}
+		if lc.c.link != link || lc.x != nextX || lc.y != nextY {
+			if len(link) > 0 {
+				b.WriteString("\x1b]8;;\x1b\\")
+			}
+			link = lc.c.link
+			fmt.Fprintf(&b, "\x1b[%d;%dH\x1b]8;;%s\x1b\\", lc.y+1, lc.x+1, escapeText(linkTarget(link)))
+			nextX = -1
+		}
+		if nextX < 0 || lc.style != style {
+			style = lc.style
+			b.WriteString(sgr(style))
+		}
+		b.WriteString(string(lc.c.runes))
+		nextX, nextY = lc.x+lc.c.width, lc.y
+	}
+	if len(link) == 0 {
+		return ""
+	}
+	return "\x1b7" + b.String() + "\x1b]8;;\x1b\\\x1b8"
+}
+
+// sgr is the escape sequence that sets the style.
+func sgr(style tcell.Style) string {
+	fg, bg, attr := style.Decompose()
+	codes := []string{"0"}
+	for _, a := range []struct {
+		mask tcell.AttrMask
+		code string
+	}{
+		{tcell.AttrBold, "1"},
+		{tcell.AttrDim, "2"},
+		{tcell.AttrItalic, "3"},
+		{tcell.AttrUnderline, "4"},
+		{tcell.AttrBlink, "5"},
+		{tcell.AttrReverse, "7"},
+		{tcell.AttrStrikeThrough, "9"},
+	} {
+		if attr&a.mask != 0 {
+			codes = append(codes, a.code)
+		}
+	}
+	codes = append(codes, sgrColor(fg, "3"), sgrColor(bg, "4"))
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// sgrColor is the SGR parameter of the color, kind is "3" for the
+// foreground and "4" for the background.
+func sgrColor(c tcell.Color, kind string) string {
+	switch {
+	case c&tcell.ColorIsRGB != 0:
+		r, g, b := c.RGB()
+		return fmt.Sprintf("%s8;2;%d;%d;%d", kind, r, g, b)
+	case c&tcell.ColorValid != 0:
+		return fmt.Sprintf("%s8;5;%d", kind, c&0xff)
+	}
+	return kind + "9"
+}
+
+// Links lists the links sent in the chat, newest first.
+func (c *Chat) Links() []string {
+	var links []string
+	seen := make(map[string]bool)
+	for i := len(c.allMessages) - 1; i >= 0; i-- {
+		m := c.allMessages[i]
+		if m.deleted || !m.finished {
+			continue
+		}
+		for _, r := range findURLs(m.text) {
+			url := m.text[r[0]:r[1]]
+			if !seen[url] {
+				seen[url] = true
+				links = append(links, url)
+			}
+		}
+	}
+	return links
+}
+
+// LinkPicker is an overlay with the links of the chat.
+type LinkPicker struct {
+	ui     *UI
+	links  []string
+	active int
+}
+
+func NewLinkPicker(ui *UI, links []string) *LinkPicker {
+	lp := LinkPicker{
+		ui:    ui,
+		links: links,
+	}
+	return &lp
+}
+
+func (lp *LinkPicker) MenuUp() {
+	if lp.active > 0 {
+		lp.active--
+	}
+}
+
+func (lp *LinkPicker) MenuDown() {
+	if lp.active < len(lp.links)-1 {
+		lp.active++
+	}
+}
+
+func (lp *LinkPicker) Selected() string {
+	return lp.links[lp.active]
+}
+
+// rect is where the picker is drawn, in the middle of the screen and as
+// wide as the longest link allows.
+func (lp *LinkPicker) rect() (int, int, int, int) {
+	w, h := lp.ui.tcs.Size()
+	width := 0
+	for _, l := range lp.links {
+		if lw := stringWidth(l); lw > width {
+			width = lw
+		}
+	}
+	width += 4
+	if width > w-4 {
+		width = w - 4
+	}
+	height := len(lp.links) + 2
+	if height > h-2 {
+		height = h - 2
+	}
+	return (w - width) / 2, (h - height) / 2, width, height
+}
+
+// first is the first link shown when they don't all fit.
+func (lp *LinkPicker) first(height int) int {
+	if lp.active < height-2 {
+		return 0
+	}
+	return lp.active - height + 3
+}
+
+// Click copies the link under the click, a click outside the picker closes
+// it.
+func (lp *LinkPicker) Click(cx, cy int) *Event {
+	x, y, width, height := lp.rect()
+	if cx < x || cx >= x+width || cy < y || cy >= y+height {
+		return &eventBack
+	}
+	i := lp.first(height) + cy - y - 1
+	if cy <= y || cy >= y+height-1 || i >= len(lp.links) {
+		return nil
+	}
+	lp.active = i
+	return &eventMenuSelect
+}
+
+func (lp *LinkPicker) Draw() {
+	x, y, width, height := lp.rect()
+	lp.ui.FillRect(x, y, width, height, titleStyle)
+	lp.ui.DrawCells(x+1, y, "Links · Enter: copy, Esc: close", titleStyle)
+	first := lp.first(height)
+	for i := first; i < len(lp.links) && i-first < height-2; i++ {
+		style := titleStyle
+		if i == lp.active {
+			style = menuActiveItemStyle
+		}
+		lp.ui.FillRect(x+1, y+1+i-first, width-2, 1, style)
+		lp.ui.DrawCells(x+2, y+1+i-first, truncate(lp.links[i], width-4), style)
+	}
+}
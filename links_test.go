@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestHyperlinkEscapes(t *testing.T) {
+	sim := tcell.NewSimulationScreen("UTF-8")
+	if err := sim.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+	sim.SetSize(20, 3)
+	ui := &UI{tcs: sim, width: 20, hyperlinks: true}
+	style := tcell.StyleDefault
+	ui.drawRow(style, 0, markupLines("go www.a.b now", markupRaw)[0])
+	ui.drawRow(style, 1, markupLines("no links", markupRaw)[0])
+	ui.drawRow(style, 2, markupLines("x http://c.d", markupRaw)[0])
+	// an overlay drawn over the last character of the second link
+	sim.SetContent(11, 2, '|', nil, style)
+
+	link := sgr(markupStyle(style, markLink))
+	want := "\x1b7" +
+		"\x1b[1;4H\x1b]8;;http://www.a.b\x1b\\" + link + "www.a.b" +
+		"\x1b]8;;\x1b\\\x1b[3;3H\x1b]8;;http://c.d\x1b\\" + link + "http://c." +
+		"\x1b]8;;\x1b\\\x1b8"
+	if got := ui.hyperlinkEscapes(); got != want {
+		t.Errorf("hyperlinkEscapes() = %q, want %q", got, want)
+	}
+
+	ui.links = nil
+	if got := ui.hyperlinkEscapes(); got != "" {
+		t.Errorf("hyperlinkEscapes() without links = %q, want \"\"", got)
+	}
+}
+
+func TestSGR(t *testing.T) {
+	tests := []struct {
+		style tcell.Style
+		want  string
+	}{
+		{tcell.StyleDefault, "\x1b[0;39;49m"},
+		{tcell.StyleDefault.Bold(true).Underline(true), "\x1b[0;1;4;39;49m"},
+		{tcell.StyleDefault.Foreground(tcell.ColorRed).Background(tcell.PaletteColor(236)), "\x1b[0;38;5;9;48;5;236m"},
+		{tcell.StyleDefault.Foreground(tcell.NewRGBColor(1, 2, 3)).Reverse(true), "\x1b[0;7;38;2;1;2;3;49m"},
+	}
+	for _, tt := range tests {
+		if got := sgr(tt.style); got != tt.want {
+			t.Errorf("sgr(%v) = %q, want %q", tt.style, got, tt.want)
+		}
+	}
+}
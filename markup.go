@@ -0,0 +1,207 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// markAttr is the formatting a cell got from the markup of the message.
+type markAttr uint8
+
+const (
+	markBold markAttr = 1 << iota
+	markItalic
+	markCode
+	markQuote
+	markLink
+	// words found by a search
+	markMatch
+)
+
+// MarkupMode is how the markup of a text is rendered.
+type MarkupMode int
+
+const (
+	// the text is shown as it was typed
+	markupRaw MarkupMode = 0
+	// markers without a closing one are shown as they are
+	markupClosed MarkupMode = 1
+	// a marker formats the rest of the line until it is closed, for drafts
+	// that are still typed
+	markupProgressive MarkupMode = 2
+)
+
+const fenceMarker = "```"
+
+// markupLines renders *bold*, _italic_, `code`, fenced code blocks and
+// "> quotes" as lines of cells with formatting. The markers themselves
+// aren't shown. Links are highlighted in the raw text too.
+func markupLines(text string, mode MarkupMode) []row {
+	var lines []row
+	fenced := false
+	for _, line := range strings.Split(text, "\n") {
+		if mode == markupRaw {
+			lines = append(lines, markupInline(line, 0, mode))
+			continue
+		}
+		trimmed := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(trimmed, fenceMarker) {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			lines = append(lines, markCells(layoutCells(line), markCode))
+			continue
+		}
+		var attr markAttr
+		if strings.HasPrefix(trimmed, ">") {
+			indent := line[:len(line)-len(trimmed)]
+			line = indent + "▌ " + strings.TrimPrefix(trimmed[1:], " ")
+			attr = markQuote
+		}
+		lines = append(lines, markupInline(line, attr, mode))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, row{})
+	}
+	return lines
+}
+
+func markupInline(line string, attr markAttr, mode MarkupMode) row {
+	runes := []rune(line)
+	// links by the rune they start at
+	links := make(map[int]int)
+	for _, r := range findURLs(line) {
+		from := utf8.RuneCountInString(line[:r[0]])
+		links[from] = from + utf8.RuneCountInString(line[r[0]:r[1]])
+	}
+	var cells row
+	start := 0
+	flush := func(end int) {
+		cells = append(cells, markCells(layoutCells(string(runes[start:end])), attr)...)
+	}
+	for i := 0; i < len(runes); i++ {
+		if end, ok := links[i]; ok {
+			flush(i)
+			url := string(runes[i:end])
+			link := markCells(layoutCells(url), attr|markLink)
+			for j := range link {
+				link[j].link = url
+			}
+			cells = append(cells, link...)
+			i = end - 1
+			start = end
+			continue
+		}
+		r := runes[i]
+		if mode == markupRaw {
+			continue
+		}
+		switch r {
+		case '`':
+			end := indexRune(runes, i+1, '`')
+			if end < 0 && mode != markupProgressive {
+				continue
+			}
+			if end < 0 {
+				end = len(runes)
+			}
+			flush(i)
+			cells = append(cells, markCells(layoutCells(string(runes[i+1:end])), attr|markCode)...)
+			i = end
+			start = end + 1
+		case '*', '_':
+			flag := markBold
+			if r == '_' {
+				flag = markItalic
+			}
+			switch {
+			case attr&flag != 0 && closesMarker(runes, i):
+				flush(i)
+				attr &^= flag
+			case attr&flag == 0 && opensMarker(runes, i) &&
+				(mode == markupProgressive || hasCloser(runes, i)):
+				flush(i)
+				attr |= flag
+			default:
+				continue
+			}
+			start = i + 1
+		}
+	}
+	if start < len(runes) {
+		flush(len(runes))
+	}
+	return cells
+}
+
+// opensMarker reports whether the marker at i can start formatting: it is
+// followed by text, and "_" isn't inside a word like in snake_case.
+func opensMarker(runes []rune, i int) bool {
+	if i+1 >= len(runes) || unicode.IsSpace(runes[i+1]) {
+		return false
+	}
+	return runes[i] != '_' || i == 0 || !isWordRune(runes[i-1])
+}
+
+func closesMarker(runes []rune, i int) bool {
+	if i == 0 || unicode.IsSpace(runes[i-1]) {
+		return false
+	}
+	return runes[i] != '_' || i+1 == len(runes) || !isWordRune(runes[i+1])
+}
+
+func hasCloser(runes []rune, i int) bool {
+	for j := i + 2; j < len(runes); j++ {
+		if runes[j] == runes[i] && closesMarker(runes, j) {
+			return true
+		}
+	}
+	return false
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func markCells(cells row, attr markAttr) row {
+	for i := range cells {
+		cells[i].attr |= attr
+	}
+	return cells
+}
+
+// markupStyle adds the formatting of a cell to the style of the text.
+func markupStyle(style tcell.Style, attr markAttr) tcell.Style {
+	if attr&markBold != 0 {
+		style = style.Bold(true)
+	}
+	if attr&markItalic != 0 {
+		style = style.Italic(true)
+	}
+	if attr&markCode != 0 {
+		style = style.Reverse(true)
+	}
+	if attr&markQuote != 0 {
+		style = style.Dim(true)
+	}
+	if attr&markLink != 0 {
+		style = style.Underline(true)
+	}
+	if attr&markMatch != 0 {
+		style = style.Reverse(true).Bold(true)
+	}
+	return style
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// markedLines writes the runs of formatted cells as [attrs:text], with the
+// attributes as letters: b bold, i italic, c code, q quote, l link.
+func markedLines(rows []row) []string {
+	var lines []string
+	for _, r := range rows {
+		s := ""
+		for i := 0; i < len(r); {
+			j := i
+			text := ""
+			for ; j < len(r) && r[j].attr == r[i].attr; j++ {
+				text += string(r[j].runes)
+			}
+			if r[i].attr == 0 {
+				s += text
+			} else {
+				s += "[" + attrLetters(r[i].attr) + ":" + text + "]"
+			}
+			i = j
+		}
+		lines = append(lines, s)
+	}
+	return lines
+}
+
+func attrLetters(attr markAttr) string {
+	s := ""
+	for i, l := range "bicql" {
+		if attr&(1<<i) != 0 {
+			s += string(l)
+		}
+	}
+	return s
+}
+
+func TestMarkupLines(t *testing.T) {
+	tests := []struct {
+		text string
+		mode MarkupMode
+		want []string
+	}{
+		{"", markupClosed, []string{""}},
+		{"plain text", markupClosed, []string{"plain text"}},
+		{"a *bold* b", markupClosed, []string{"a [b:bold] b"}},
+		{"a _italic_ b", markupClosed, []string{"a [i:italic] b"}},
+		{"*_both_*", markupClosed, []string{"[bi:both]"}},
+		{"*bold _and_ more*", markupClosed, []string{"[b:bold ][bi:and][b: more]"}},
+		{"run `go *vet*` now", markupClosed, []string{"run [c:go *vet*] now"}},
+		{"snake_case_name", markupClosed, []string{"snake_case_name"}},
+		{"2 * 3 * 4", markupClosed, []string{"2 * 3 * 4"}},
+		{"*not closed", markupClosed, []string{"*not closed"}},
+		{"`not closed", markupClosed, []string{"`not closed"}},
+		{"*bold\nnext* line", markupClosed, []string{"*bold", "next* line"}},
+		{"> quoted *text*", markupClosed, []string{"[q:▌ quoted ][bq:text]"}},
+		{"  >indented", markupClosed, []string{"[q:  ▌ indented]"}},
+		{"a > b", markupClosed, []string{"a > b"}},
+		{"```\ncode *x*\n```\nafter", markupClosed, []string{"[c:code *x*]", "after"}},
+		{"before\n  ```go\nfunc f()\n", markupClosed, []string{"before", "[c:func f()]", ""}},
+		{"see www.a.b now", markupClosed, []string{"see [l:www.a.b] now"}},
+		{"*see www.a.b*", markupClosed, []string{"[b:see ][bl:www.a.b]"}},
+
+		{"a *bold and", markupProgressive, []string{"a [b:bold and]"}},
+		{"a _it", markupProgressive, []string{"a [i:it]"}},
+		{"a `code", markupProgressive, []string{"a [c:code]"}},
+		{"a *done* b", markupProgressive, []string{"a [b:done] b"}},
+		{"a * b", markupProgressive, []string{"a * b"}},
+		{"snake_case", markupProgressive, []string{"snake_case"}},
+
+		{"a *bold* _it_ `code`", markupRaw, []string{"a *bold* _it_ `code`"}},
+		{"> not a quote", markupRaw, []string{"> not a quote"}},
+		{"```\nx\n```", markupRaw, []string{"```", "x", "```"}},
+		{"raw www.a.b", markupRaw, []string{"raw [l:www.a.b]"}},
+	}
+	for _, tt := range tests {
+		got := markedLines(markupLines(tt.text, tt.mode))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("markupLines(%q, %d) = %q, want %q", tt.text, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestMarkupLinks(t *testing.T) {
+	r := markupLines("go to https://go.dev/doc.", markupClosed)[0]
+	for i, c := range r {
+		want := ""
+		if i >= 6 && i < 24 {
+			want = "https://go.dev/doc"
+		}
+		if c.link != want {
+			t.Errorf("cell %d %q has link %q, want %q", i, string(c.runes), c.link, want)
+		}
+	}
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"fmt"
+	"strings"
 	"time"
-	"unicode/utf8"
+)
+
+type MessageStatus int
 
-	"github.com/mattn/go-runewidth"
+const (
+	statusSent      MessageStatus = 0
+	statusDelivered MessageStatus = 1
+	statusRead      MessageStatus = 2
 )
 
+var statusGlyphs = map[MessageStatus]string{
+	statusSent:      "✓",
+	statusDelivered: "✓✓",
+	statusRead:      "◉",
+}
+
 type Message struct {
 	text     string
 	order    uint
@@ -14,59 +27,157 @@ type Message struct {
 	sender   string
 	finished bool
 	own      bool
-	runes    [][]rune
+	// how the message was wrapped when it was drawn last
+	layout textLayout
+	// status of own messages, the lowest one among the receivers
+	status   MessageStatus
+	receipts map[string]MessageStatus
+	// seen is set once a received message was shown on the screen
+	seen bool
+	// previous texts of an edited message, oldest first
+	history  []string
+	revision uint
+	editing  bool
+	editText string
+	// deleted messages stay in the list as tombstones
+	deleted bool
+	// queued own messages are finished when the undo-send window is over
+	queued bool
+	sendAt time.Time
+	// replyTo is the message this one answers
+	replyTo   *PackedRef
+	reactions []*Reaction
+	// typing is set while the sender types a draft they don't show us
+	typing bool
+	// when the sender started typing the draft, ts changes with every key
+	typingSince time.Time
+	// cursor of a draft, in runes from the end
+	cursor int
 }
 
-func (m *Message) SetText(t string) {
-	m.text = t
-	m.updateRunes()
+// Reaction is an emoji together with everyone who reacted with it.
+type Reaction struct {
+	emoji   string
+	senders []string
 }
 
-func (m *Message) updateRunes() {
-	runes := make([][]rune, utf8.RuneCountInString(m.text))
-	i := 0
-	var deferred []rune
-	dwidth := 0
-	zwj := false
-	addToLine := func(r []rune, runeWidth int) {
-		if len(r) != 0 {
-			runes[i] = r
-			i += runeWidth
+func (m *Message) HasReaction(emoji string, sender string) bool {
+	for _, r := range m.reactions {
+		if r.emoji != emoji {
+			continue
 		}
-	}
-	for _, r := range m.text {
-		if r == '\u200d' {
-			if len(deferred) == 0 {
-				deferred = append(deferred, ' ')
-				dwidth = 1
+		for _, s := range r.senders {
+			if s == sender {
+				return true
 			}
-			deferred = append(deferred, r)
-			zwj = true
-			continue
 		}
-		if zwj {
-			deferred = append(deferred, r)
-			zwj = false
+	}
+	return false
+}
+
+func (m *Message) SetReaction(emoji string, sender string, on bool) {
+	if m.HasReaction(emoji, sender) == on {
+		return
+	}
+	for i, r := range m.reactions {
+		if r.emoji != emoji {
 			continue
 		}
-		switch runewidth.RuneWidth(r) {
-		case 0:
-			if len(deferred) == 0 {
-				deferred = append(deferred, ' ')
-				dwidth = 1
+		if on {
+			r.senders = append(r.senders, sender)
+			return
+		}
+		for j, s := range r.senders {
+			if s == sender {
+				r.senders = append(r.senders[:j], r.senders[j+1:]...)
+				break
 			}
-		case 1:
-			addToLine(deferred, dwidth)
-			deferred = nil
-			dwidth = 1
-		case 2:
-			addToLine(deferred, dwidth)
-			deferred = nil
-			dwidth = 2
 		}
-		deferred = append(deferred, r)
+		if len(r.senders) == 0 {
+			m.reactions = append(m.reactions[:i], m.reactions[i+1:]...)
+		}
+		return
 	}
-	addToLine(deferred, dwidth)
+	m.reactions = append(m.reactions, &Reaction{emoji, []string{sender}})
+}
+
+// ReactionsLine aggregates the reactions, e.g. "👍 2  🎉 1".
+func (m *Message) ReactionsLine() string {
+	parts := make([]string, len(m.reactions))
+	for i, r := range m.reactions {
+		parts[i] = fmt.Sprintf("%s %d", r.emoji, len(r.senders))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// Ref is how the message is referred to in the protocol.
+func (m *Message) Ref() *PackedRef {
+	return &PackedRef{
+		Addr:  m.sender,
+		Order: m.order,
+	}
+}
+
+func (m *Message) Delete() {
+	m.deleted = true
+	m.editing = false
+	m.history = nil
+	m.SetText("")
+}
+
+func (m *Message) Edited() bool {
+	return m.revision > 0
+}
+
+// Revisions returns every text the message had, the current one last.
+func (m *Message) Revisions() []string {
+	return append(append([]string(nil), m.history...), m.text)
+}
+
+// ApplyRevision applies a live or finished edit of the message. Finishing
+// the current revision again cancels an edit in progress.
+func (m *Message) ApplyRevision(text string, revision uint, finished bool) {
+	if m.deleted {
+		return
+	}
+	switch {
+	case revision <= m.revision:
+		if finished {
+			m.editing = false
+		}
+	case finished:
+		m.history = append(m.history, m.text)
+		m.SetText(text)
+		m.revision = revision
+		m.editing = false
+	default:
+		m.editing = true
+		m.editText = text
+	}
+}
+
+// SetReceipt records the status reported by one of the receivers.
+func (m *Message) SetReceipt(addr string, s MessageStatus, receivers int) {
+	if m.receipts == nil {
+		m.receipts = make(map[string]MessageStatus)
+	}
+	if s <= m.receipts[addr] {
+		return
+	}
+	m.receipts[addr] = s
+	if len(m.receipts) < receivers {
+		return
+	}
+	m.status = statusRead
+	for _, rs := range m.receipts {
+		if rs < m.status {
+			m.status = rs
+		}
+	}
+}
+
+func (m *Message) SetText(t string) {
+	m.text = t
 }
 
 func NewMessage(
@@ -85,6 +196,5 @@ func NewMessage(
 		finished: finished,
 		own:      own,
 	}
-	m.updateRunes()
 	return &m
 }
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestMessageReceipts(t *testing.T) {
+	type receipt struct {
+		addr   string
+		status MessageStatus
+	}
+	tests := []struct {
+		name      string
+		receivers int
+		receipts  []receipt
+		want      MessageStatus
+	}{
+		{"no receipts", 1, nil, statusSent},
+		{"delivered", 1, []receipt{{"a", statusDelivered}}, statusDelivered},
+		{"read", 1, []receipt{{"a", statusDelivered}, {"a", statusRead}}, statusRead},
+		{"read without delivered", 1, []receipt{{"a", statusRead}}, statusRead},
+		{"late delivered after read", 1, []receipt{{"a", statusRead}, {"a", statusDelivered}}, statusRead},
+		{"group waits for everyone", 3, []receipt{{"a", statusRead}, {"b", statusRead}}, statusSent},
+		{"group delivered to all", 3, []receipt{{"a", statusRead}, {"b", statusDelivered}, {"c", statusRead}}, statusDelivered},
+		{"group read by all", 2, []receipt{{"a", statusDelivered}, {"b", statusRead}, {"a", statusRead}}, statusRead},
+		{"group repeated receipts", 2, []receipt{{"a", statusRead}, {"a", statusRead}}, statusSent},
+	}
+	for _, tt := range tests {
+		m := &Message{}
+		for _, r := range tt.receipts {
+			m.SetReceipt(r.addr, r.status, tt.receivers)
+		}
+		if m.status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, m.status, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// NotifyMode is how we tell about messages that came to chats that aren't
+// on the screen.
+type NotifyMode int
+
+const (
+	notifyBell   NotifyMode = 0
+	notifyOSC9   NotifyMode = 1
+	notifyOSC777 NotifyMode = 2
+	notifyOff    NotifyMode = 3
+)
+
+const appTitle = "livechat"
+
+var notifyModeKeys = map[string]NotifyMode{
+	"bell":   notifyBell,
+	"osc9":   notifyOSC9,
+	"osc777": notifyOSC777,
+	"off":    notifyOff,
+}
+
+func notifyModeKey(mode NotifyMode) string {
+	for k, m := range notifyModeKeys {
+		if m == mode {
+			return k
+		}
+	}
+	return "bell"
+}
+
+// Notify rings the bell or shows a desktop notification with the OSC 9
+// (iTerm2, Windows Terminal) or OSC 777 (rxvt, foot, VTE) escape sequence.
+func (ui *UI) Notify(mode NotifyMode, title, body string) {
+	switch mode {
+	case notifyBell:
+		ui.tcs.Beep()
+	case notifyOSC9:
+		ui.writeEscape("\x1b]9;%s\a", escapeText(title+": "+body))
+	case notifyOSC777:
+		// the fields are separated by semicolons
+		title = strings.ReplaceAll(escapeText(title), ";", ",")
+		ui.writeEscape("\x1b]777;notify;%s;%s\a", title, escapeText(body))
+	}
+}
+
+// SetTitle changes the title of the terminal window.
+func (ui *UI) SetTitle(title string) {
+	ui.writeEscape("\x1b]2;%s\a", escapeText(title))
+}
+
+// escapeText drops the control characters that would end an escape sequence
+// early.
+func escapeText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// notifyUnread tells about new messages in the chats that aren't on the
+// screen and keeps the number of unread messages in the terminal title.
+func (a *App) notifyUnread() {
+	if a.server == nil {
+		return
+	}
+	total := 0
+	var latest *Chat
+	for _, c := range a.server.chats {
+		n := c.Unread()
+		if n > c.notified {
+			latest = c
+		}
+		c.notified = n
+		total += n
+	}
+	if latest != nil {
+		a.ui.Notify(a.settings.Notifications, appTitle, "New message in "+latest.Label())
+	}
+	if total != a.unread {
+		a.unread = total
+		title := appTitle
+		if total > 0 {
+			title = fmt.Sprintf("(%d) %s", total, appTitle)
+		}
+		a.ui.SetTitle(title)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "net"
+
+type Peer struct {
+	address         string
+	updAddr         *net.UDPAddr
+	name            string
+	status          string
+	profileReceived bool
+}
+
+func NewPeer(address string) *Peer {
+	p := Peer{address: address}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err == nil {
+		p.updAddr = addr
+	}
+	return &p
+}
+
+func (p *Peer) SetProfile(pp *PackedProfile) {
+	if pp == nil {
+		return
+	}
+	p.name = pp.Name
+	p.status = pp.Status
+	p.profileReceived = true
+}
+
+// Name prefers the contact nickname over the display name of the peer.
+func (p *Peer) Name(contacts *Contacts) string {
+	if ct := contacts.FindByAddress(p.address); ct != nil {
+		return ct.Nickname
+	}
+	if len(p.name) > 0 {
+		return p.name
+	}
+	return p.address
+}
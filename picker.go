@@ -0,0 +1,79 @@
+package main
+
+var reactionEmoji = []string{
+	"👍", "👎", "❤️", "😂", "😮", "😢",
+	"🎉", "🔥", "👀", "🙏", "👏", "✅",
+	"🤔", "🚀", "💯", "👨‍💻", "🤷‍♀️", "🏳️‍🌈",
+}
+
+const (
+	pickerColumns   = 6
+	pickerCellWidth = 4
+)
+
+// ReactionPicker is an overlay with a grid of emoji to react with.
+type ReactionPicker struct {
+	ui     *UI
+	emoji  []string
+	active int
+}
+
+func NewReactionPicker(ui *UI) *ReactionPicker {
+	rp := ReactionPicker{
+		ui:    ui,
+		emoji: reactionEmoji,
+	}
+	return &rp
+}
+
+func (rp *ReactionPicker) Move(dx, dy int) {
+	i := rp.active + dx + dy*pickerColumns
+	if i >= 0 && i < len(rp.emoji) {
+		rp.active = i
+	}
+}
+
+func (rp *ReactionPicker) Selected() string {
+	return rp.emoji[rp.active]
+}
+
+// rect is where the picker is drawn, in the middle of the screen.
+func (rp *ReactionPicker) rect() (int, int, int, int) {
+	rows := (len(rp.emoji) + pickerColumns - 1) / pickerColumns
+	w, h := rp.ui.tcs.Size()
+	width := pickerColumns*pickerCellWidth + 2
+	height := rows + 2
+	return (w - width) / 2, (h - height) / 2, width, height
+}
+
+// Click reacts with the emoji under the click, a click outside the picker
+// closes it.
+func (rp *ReactionPicker) Click(cx, cy int) *Event {
+	x, y, width, height := rp.rect()
+	if cx < x || cx >= x+width || cy < y || cy >= y+height {
+		return &eventBack
+	}
+	col := (cx - x - 1) / pickerCellWidth
+	i := (cy-y-1)*pickerColumns + col
+	if cx <= x || col >= pickerColumns || cy <= y || i >= len(rp.emoji) {
+		return nil
+	}
+	rp.active = i
+	return &eventReact
+}
+
+func (rp *ReactionPicker) Draw() {
+	x, y, width, height := rp.rect()
+	rp.ui.FillRect(x, y, width, height, titleStyle)
+	rp.ui.DrawCells(x+1, y, "React", titleStyle)
+	for i, e := range rp.emoji {
+		style := menuItemStyle
+		if i == rp.active {
+			style = menuActiveItemStyle
+		}
+		cx := x + 1 + (i%pickerColumns)*pickerCellWidth
+		cy := y + 1 + i/pickerColumns
+		rp.ui.FillRect(cx, cy, pickerCellWidth, 1, style)
+		rp.ui.DrawCells(cx+1, cy, e, style)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import "time"
+
+// PreviewMode is how much of a draft the peer sees while we type.
+type PreviewMode int
+
+const (
+	previewInherit    PreviewMode = -1
+	previewFull       PreviewMode = 0
+	previewIndicator  PreviewMode = 1
+	previewAfterPause PreviewMode = 2
+	previewOff        PreviewMode = 3
+)
+
+// Drafts are shown in the "preview after pause" mode once typing stops for
+// this long.
+const previewPause = 1500 * time.Millisecond
+
+var previewModeNames = map[PreviewMode]string{
+	previewInherit:    "default",
+	previewFull:       "full live preview",
+	previewIndicator:  "typing indicator only",
+	previewAfterPause: "preview after pause",
+	previewOff:        "off",
+}
+
+var previewModeKeys = map[string]PreviewMode{
+	"full":      previewFull,
+	"indicator": previewIndicator,
+	"pause":     previewAfterPause,
+	"off":       previewOff,
+}
+
+func previewModeKey(mode PreviewMode) string {
+	for k, m := range previewModeKeys {
+		if m == mode {
+			return k
+		}
+	}
+	return "full"
+}
+
+func (c *Chat) PreviewMode() PreviewMode {
+	if c.previewMode == previewInherit {
+		return c.server.settings.PreviewMode
+	}
+	return c.previewMode
+}
+
+// CyclePreviewMode switches the mode of this chat, going back to the global
+// one after the last mode.
+func (c *Chat) CyclePreviewMode() {
+	c.previewMode++
+	if c.previewMode > previewOff {
+		c.previewMode = previewInherit
+	}
+	c.AnnounceMode()
+	if c.PreviewMode() == previewOff && !c.previewPaused {
+		// Take back what the peer has seen of the draft so far
+		c.sendTyping(false)
+	}
+	c.Typing(c.draft, c.draftCursor)
+}
+
+// TogglePause stops streaming the current draft until it is sent or the
+// pause is toggled again.
+func (c *Chat) TogglePause() {
+	c.previewPaused = !c.previewPaused
+	if c.previewPaused {
+		c.sendTyping(false)
+	} else {
+		c.Typing(c.draft, c.draftCursor)
+	}
+}
+
+func (c *Chat) AnnounceMode() {
+	c.broadcast(&PackedMsg{Type: packetMode})
+}
+
+// Typing streams the draft as far as the preview mode allows. The cursor
+// is counted in runes from the end of the draft.
+func (c *Chat) Typing(msg string, cursor int) {
+	c.draft = msg
+	c.draftCursor = cursor
+	c.draftAt = time.Now()
+	if c.previewPaused {
+		return
+	}
+	switch c.PreviewMode() {
+	case previewFull:
+		c.sendDraft(msg)
+	case previewIndicator:
+		c.sendTyping(len(msg) > 0)
+	case previewAfterPause:
+		if len(c.sentDraft) == 0 {
+			c.sendTyping(len(msg) > 0)
+		}
+		if c.flushTimer == nil {
+			c.flushTimer = time.AfterFunc(previewPause, c.server.requestFlush)
+		} else {
+			c.flushTimer.Reset(previewPause)
+		}
+	}
+}
+
+// flushPreview shows the draft to the peer after a pause in typing.
+func (c *Chat) flushPreview(now time.Time) {
+	if c.previewPaused || c.PreviewMode() != previewAfterPause {
+		return
+	}
+	if c.draft != c.sentDraft && now.Sub(c.draftAt) >= previewPause {
+		c.sendDraft(c.draft)
+	}
+}
+
+func (c *Chat) resetDraft() {
+	c.draft = ""
+	c.draftCursor = 0
+	c.sentDraft = ""
+	c.previewPaused = false
+}
+
+func (c *Chat) sendDraft(msg string) {
+	c.sentDraft = msg
+	c.streamDraft(msg, len(msg) > 0)
+}
+
+func (c *Chat) sendTyping(typing bool) {
+	c.sentDraft = ""
+	c.streamDraft("", typing)
+}
+
+func (c *Chat) streamDraft(msg string, typing bool) {
+	cursor := 0
+	if len(msg) > 0 {
+		cursor = c.draftCursor
+	}
+	if m := c.editing; m != nil {
+		c.broadcast(&PackedMsg{
+			Msg:      msg,
+			Order:    m.order,
+			Revision: m.revision + 1,
+			Typing:   typing,
+			Cursor:   cursor,
+		})
+		return
+	}
+	c.broadcast(&PackedMsg{
+		Msg:    msg,
+		Order:  c.amountOwnMsgs,
+		Reply:  c.replyRef(),
+		Typing: typing,
+		Cursor: cursor,
+	})
+}
@@ -1,6 +1,9 @@
 package main
 
-import "github.com/gdamore/tcell/v2"
+import (
+	"fmt"
+	"strings"
+)
 
 type Screen interface {
 	Draw()
@@ -12,6 +15,19 @@ type ScreenWithMenu interface {
 	GetMenuEvent() *Event
 }
 
+type ScreenWithInput interface {
+	InitialInput() string
+}
+
+// ScreenWithMouse returns the event for a click at the position, if any.
+type ScreenWithMouse interface {
+	Click(x, y int) *Event
+}
+
+type ScreenWithScroll interface {
+	Scroll(d int)
+}
+
 type StartScreen struct {
 	ui *UI
 	Menu
@@ -31,10 +47,20 @@ func (ss *StartScreen) Draw() {
 		if i == ss.Menu.active {
 			style = menuActiveItemStyle
 		}
+		top := ss.ui.topRows
 		ss.ui.DrawText(item.label, style, false)
+		ss.ui.Mark(top, ss.ui.topRows, i)
 	}
 }
 
+func (ss *StartScreen) Click(x, y int) *Event {
+	if i, ok := ss.ui.ItemAt(x, y).(int); ok {
+		ss.Menu.active = i
+		return ss.GetMenuEvent()
+	}
+	return nil
+}
+
 type ServerScreen struct {
 	ui         *UI
 	server     *Server
@@ -75,13 +101,23 @@ func (ss *ServerScreen) GetMenuEvent() *Event {
 
 func (ss *ServerScreen) Draw() {
 	if len(ss.server.address) > 0 {
-		ss.ui.DrawText("Server: "+ss.server.address, titleStyle, false)
+		title := "Server: " + ss.server.address
+		if len(ss.server.settings.Name) > 0 {
+			title += " (" + ss.server.settings.Name + ")"
+		}
+		ss.ui.DrawText(title, titleStyle, false)
 		for i, c := range ss.server.chats {
 			style := menuItemStyle
 			if i == ss.activeChat {
 				style = menuActiveItemStyle
 			}
-			ss.ui.DrawText("Chat with "+c.remoteAddress, style, false)
+			label := c.Label()
+			if n := c.Unread(); n > 0 {
+				label += fmt.Sprintf(" (%d new)", n)
+			}
+			top := ss.ui.topRows
+			ss.ui.DrawText(label, style, false)
+			ss.ui.Mark(top, ss.ui.topRows, i)
 		}
 		chatsLen := len(ss.server.chats)
 		for i, item := range ss.menu.items {
@@ -89,7 +125,9 @@ func (ss *ServerScreen) Draw() {
 			if i+chatsLen == ss.activeChat {
 				style = menuActiveItemStyle
 			}
+			top := ss.ui.topRows
 			ss.ui.DrawText(item.label, style, false)
+			ss.ui.Mark(top, ss.ui.topRows, i+chatsLen)
 		}
 	} else {
 		ss.ui.DrawText("Creating Server...", titleStyle, false)
@@ -97,6 +135,14 @@ func (ss *ServerScreen) Draw() {
 
 }
 
+func (ss *ServerScreen) Click(x, y int) *Event {
+	if i, ok := ss.ui.ItemAt(x, y).(int); ok {
+		ss.activeChat = i
+		return ss.GetMenuEvent()
+	}
+	return nil
+}
+
 type ConnectServerScreen struct {
 	ui *UI
 }
@@ -113,36 +159,146 @@ func (ss *ConnectServerScreen) Draw() {
 	ss.ui.DrawText(ss.ui.typed, inputStyle, true)
 }
 
-type ChatScreen struct {
-	ui   *UI
-	chat *Chat
+type ContactsScreen struct {
+	ui            *UI
+	contacts      *Contacts
+	menu          *Menu
+	activeContact int
 }
 
-func NewChatScreen(ui *UI, c *Chat) *ChatScreen {
-	cs := ChatScreen{
-		ui:   ui,
-		chat: c,
+func NewContactsScreen(ui *UI, c *Contacts, m *Menu) *ContactsScreen {
+	cs := ContactsScreen{
+		ui:       ui,
+		contacts: c,
+		menu:     m,
 	}
 	return &cs
 }
 
-func (cs *ChatScreen) Draw() {
-	cs.ui.DrawText("Chat with "+cs.chat.remoteAddress, titleStyle, false)
-	cs.ui.DrawTextBottom(cs.ui.typed, inputStyle, true)
-	for i := len(cs.chat.allMessages) - 1; i > 0; i-- {
-		msg := cs.chat.allMessages[i]
-		style := receivedMsgStyle
-		if msg.own {
-			style = myMsgStyle
+func (cs *ContactsScreen) MenuUp() {
+	cs.activeContact--
+	if cs.activeContact < 0 {
+		cs.activeContact = len(cs.contacts.list) + cs.menu.len - 1
+	}
+}
+
+func (cs *ContactsScreen) MenuDown() {
+	cs.activeContact++
+	if cs.activeContact >= len(cs.contacts.list)+cs.menu.len {
+		cs.activeContact = 0
+	}
+}
+
+func (cs *ContactsScreen) GetMenuEvent() *Event {
+	if cs.activeContact < len(cs.contacts.list) {
+		cs.contacts.active = cs.activeContact
+		return &eventOpenContact
+	}
+	return cs.menu.items[cs.activeContact-len(cs.contacts.list)].event
+}
+
+func (cs *ContactsScreen) Draw() {
+	cs.ui.DrawText("Contacts", titleStyle, false)
+	for i, c := range cs.contacts.list {
+		style := menuItemStyle
+		if i == cs.activeContact {
+			style = menuActiveItemStyle
+		}
+		top := cs.ui.topRows
+		cs.ui.DrawText(c.Label(), style, false)
+		cs.ui.Mark(top, cs.ui.topRows, i)
+	}
+	contactsLen := len(cs.contacts.list)
+	for i, item := range cs.menu.items {
+		style := menuItemStyle
+		if i+contactsLen == cs.activeContact {
+			style = menuActiveItemStyle
 		}
-		cs.drawMsg(msg, style)
+		top := cs.ui.topRows
+		cs.ui.DrawText(item.label, style, false)
+		cs.ui.Mark(top, cs.ui.topRows, i+contactsLen)
 	}
 }
 
-func (cs *ChatScreen) drawMsg(m *Message, style tcell.Style) {
-	msg := m.text
-	if !m.finished {
-		msg = msg + "..."
+func (cs *ContactsScreen) Click(x, y int) *Event {
+	if i, ok := cs.ui.ItemAt(x, y).(int); ok {
+		cs.activeContact = i
+		return cs.GetMenuEvent()
+	}
+	return nil
+}
+
+type ContactScreen struct {
+	ui      *UI
+	contact *Contact
+	err     string
+	Menu
+}
+
+func NewContactScreen(ui *UI, c *Contact, m *Menu) *ContactScreen {
+	cs := ContactScreen{
+		ui:      ui,
+		contact: c,
+		Menu:    *m,
+	}
+	return &cs
+}
+
+func (cs *ContactScreen) SetError(err string) {
+	cs.err = err
+}
+
+func (cs *ContactScreen) Draw() {
+	cs.ui.DrawText(cs.contact.Label(), titleStyle, false)
+	cs.ui.DrawText("Addresses: "+strings.Join(cs.contact.Addresses, ", "), menuItemStyle, false)
+	cs.ui.DrawText("Keys: "+strings.Join(cs.contact.Keys, ", "), menuItemStyle, false)
+	if len(cs.contact.Notes) > 0 {
+		cs.ui.DrawText(cs.contact.Notes, menuItemStyle, false)
+	}
+	for i, item := range cs.Menu.items {
+		style := menuItemStyle
+		if i == cs.Menu.active {
+			style = menuActiveItemStyle
+		}
+		top := cs.ui.topRows
+		cs.ui.DrawText(item.label, style, false)
+		cs.ui.Mark(top, cs.ui.topRows, i)
+	}
+	if len(cs.err) > 0 {
+		cs.ui.DrawTextBottom(cs.err, footerStyle, false)
+	}
+}
+
+func (cs *ContactScreen) Click(x, y int) *Event {
+	if i, ok := cs.ui.ItemAt(x, y).(int); ok {
+		cs.Menu.active = i
+		return cs.GetMenuEvent()
+	}
+	return nil
+}
+
+type HistoryScreen struct {
+	ui  *UI
+	msg *Message
+}
+
+func NewHistoryScreen(ui *UI, m *Message) *HistoryScreen {
+	hs := HistoryScreen{
+		ui:  ui,
+		msg: m,
+	}
+	return &hs
+}
+
+func (hs *HistoryScreen) Draw() {
+	hs.ui.DrawText("Revisions", titleStyle, false)
+	revisions := hs.msg.Revisions()
+	for i, text := range revisions {
+		label := fmt.Sprintf("%d: ", i+1)
+		if i == len(revisions)-1 {
+			label = fmt.Sprintf("%d (current): ", i+1)
+		}
+		hs.ui.DrawText(label+text, menuItemStyle, false)
 	}
-	cs.ui.DrawTextBottom(msg, style, false)
+	hs.ui.DrawTextBottom("Esc: back to chat", footerStyle, false)
 }
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	searchDateLayout = "2006-01-02"
+	searchHitsLimit  = 200
+)
+
+// SearchIndex finds messages of all chats by the words in them without
+// going through every message. Messages aren't stored, so it only knows
+// those of the current session. Messages are added as they are finished and
+// updated when they are edited or deleted.
+type SearchIndex struct {
+	mu sync.Mutex
+	// messages by the words in them
+	postings map[string]map[*Message]bool
+	// the words each message was indexed with, to update it
+	terms map[*Message][]string
+	chats map[*Message]*Chat
+	// all words sorted, for prefix lookups, nil when it needs sorting again
+	sorted []string
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings: make(map[string]map[*Message]bool),
+		terms:    make(map[*Message][]string),
+		chats:    make(map[*Message]*Chat),
+	}
+}
+
+// Update indexes the current text of the message. Unfinished and deleted
+// messages are taken out of the index.
+func (x *SearchIndex) Update(c *Chat, m *Message) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(m)
+	if !m.finished || m.deleted || m.queued {
+		return
+	}
+	terms := searchTerms(m.text)
+	for _, t := range terms {
+		if x.postings[t] == nil {
+			x.postings[t] = make(map[*Message]bool)
+			x.sorted = nil
+		}
+		x.postings[t][m] = true
+	}
+	x.terms[m] = terms
+	x.chats[m] = c
+}
+
+// Remove takes the message out of the index.
+func (x *SearchIndex) Remove(m *Message) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(m)
+}
+
+func (x *SearchIndex) remove(m *Message) {
+	for _, t := range x.terms[m] {
+		delete(x.postings[t], m)
+		if len(x.postings[t]) == 0 {
+			delete(x.postings, t)
+			x.sorted = nil
+		}
+	}
+	delete(x.terms, m)
+	delete(x.chats, m)
+}
+
+// SearchHit is a message found by a search.
+type SearchHit struct {
+	chat *Chat
+	msg  *Message
+}
+
+// Search returns the newest messages matching the query and how many match
+// in all. Every word of the query has to start a word of the message.
+func (x *SearchIndex) Search(q *SearchQuery) ([]SearchHit, int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var candidates map[*Message]bool
+	if len(q.words) == 0 {
+		candidates = make(map[*Message]bool)
+		for m := range x.chats {
+			candidates[m] = true
+		}
+	}
+	for _, w := range q.words {
+		found := x.prefixed(w)
+		if candidates == nil {
+			candidates = found
+			continue
+		}
+		for m := range candidates {
+			if !found[m] {
+				delete(candidates, m)
+			}
+		}
+	}
+	var hits []SearchHit
+	for m := range candidates {
+		c := x.chats[m]
+		if q.matches(c, m) {
+			hits = append(hits, SearchHit{c, m})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].msg.ts.After(hits[j].msg.ts)
+	})
+	total := len(hits)
+	if total > searchHitsLimit {
+		hits = hits[:searchHitsLimit]
+	}
+	return hits, total
+}
+
+// prefixed returns the messages with a word starting with the prefix.
+func (x *SearchIndex) prefixed(prefix string) map[*Message]bool {
+	if x.sorted == nil {
+		x.sorted = make([]string, 0, len(x.postings))
+		for t := range x.postings {
+			x.sorted = append(x.sorted, t)
+		}
+		sort.Strings(x.sorted)
+	}
+	found := make(map[*Message]bool)
+	i := sort.SearchStrings(x.sorted, prefix)
+	for ; i < len(x.sorted) && strings.HasPrefix(x.sorted[i], prefix); i++ {
+		for m := range x.postings[x.sorted[i]] {
+			found[m] = true
+		}
+	}
+	return found
+}
+
+func searchTerms(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range strings.FieldsFunc(strings.ToLower(text), isNotWordRune) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+func isNotWordRune(r rune) bool {
+	return !isWordRune(r)
+}
+
+// SearchQuery is what was typed in the search: words of the text, and
+// from:<sender>, after:<date> and before:<date> filters.
+type SearchQuery struct {
+	words  []string
+	from   string
+	after  time.Time
+	before time.Time
+}
+
+func ParseSearchQuery(s string) (*SearchQuery, error) {
+	q := SearchQuery{}
+	for _, f := range strings.Fields(s) {
+		key, value := "", f
+		if i := strings.Index(f, ":"); i > 0 {
+			key, value = strings.ToLower(f[:i]), f[i+1:]
+		}
+		var err error
+		switch key {
+		case "from":
+			q.from = strings.ToLower(value)
+		case "after":
+			q.after, err = time.ParseInLocation(searchDateLayout, value, time.Local)
+		case "before":
+			q.before, err = time.ParseInLocation(searchDateLayout, value, time.Local)
+		default:
+			q.words = append(q.words, searchTerms(f)...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: dates are written like %s", f, searchDateLayout)
+		}
+	}
+	return &q, nil
+}
+
+// Empty reports whether the query would find every message.
+func (q *SearchQuery) Empty() bool {
+	return len(q.words) == 0 && len(q.from) == 0 && q.after.IsZero() && q.before.IsZero()
+}
+
+// matches checks the filters, the words are already found by the index.
+// after: includes the day, before: doesn't.
+func (q *SearchQuery) matches(c *Chat, m *Message) bool {
+	if len(q.from) > 0 &&
+		!strings.Contains(strings.ToLower(c.SenderName(m.sender)), q.from) &&
+		!strings.Contains(m.sender, q.from) {
+		return false
+	}
+	if !q.after.IsZero() && m.ts.Before(q.after) {
+		return false
+	}
+	if !q.before.IsZero() && !m.ts.Before(q.before) {
+		return false
+	}
+	return true
+}
+
+// highlight lays the text out on a single row with the words of the query
+// marked. When the first match is far from the start the text is cut
+// before it.
+func (q *SearchQuery) highlight(text string) row {
+	runes := []rune(strings.ReplaceAll(text, "\n", " "))
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, w := range q.words {
+		word := []rune(w)
+		for i := 0; i+len(word) <= len(runes); i++ {
+			if i > 0 && isWordRune(runes[i-1]) || !hasPrefixFold(runes[i:], word) {
+				continue
+			}
+			for j := i; j < i+len(word); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+	start := 0
+	var cells row
+	if first > searchContext {
+		start = first - searchContext
+		cells = layoutCells("…")
+	}
+	for i := start; i < len(runes); {
+		j := i
+		for j < len(runes) && marked[j] == marked[i] {
+			j++
+		}
+		part := layoutCells(string(runes[i:j]))
+		if marked[i] {
+			part = markCells(part, markMatch)
+		}
+		cells = append(cells, part...)
+		i = j
+	}
+	return cells
+}
+
+// Characters shown before the first match of a long message.
+const searchContext = 20
+
+func hasPrefixFold(runes, prefix []rune) bool {
+	for i, r := range prefix {
+		if unicode.ToLower(runes[i]) != r {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchScreen finds messages of all chats as the query is typed.
+type SearchScreen struct {
+	ui     *UI
+	server *Server
+	query  *SearchQuery
+	hits   []SearchHit
+	total  int
+	err    string
+	active int
+	// the query the screen was opened with
+	initial string
+}
+
+func NewSearchScreen(ui *UI, s *Server, query string) *SearchScreen {
+	ss := SearchScreen{
+		ui:      ui,
+		server:  s,
+		initial: query,
+	}
+	ss.Update(query)
+	return &ss
+}
+
+func (ss *SearchScreen) InitialInput() string {
+	return ss.initial
+}
+
+// Update runs the search again for the query.
+func (ss *SearchScreen) Update(query string) {
+	q, err := ParseSearchQuery(query)
+	ss.err = ""
+	ss.active = 0
+	if err != nil {
+		ss.err = err.Error()
+		return
+	}
+	ss.query = q
+	ss.hits, ss.total = nil, 0
+	if !q.Empty() {
+		ss.hits, ss.total = ss.server.index.Search(q)
+	}
+}
+
+func (ss *SearchScreen) MenuUp() {
+	if ss.active > 0 {
+		ss.active--
+	}
+}
+
+func (ss *SearchScreen) MenuDown() {
+	if ss.active < len(ss.hits)-1 {
+		ss.active++
+	}
+}
+
+func (ss *SearchScreen) GetMenuEvent() *Event {
+	if len(ss.hits) == 0 {
+		return nil
+	}
+	return &eventOpenSearchHit
+}
+
+func (ss *SearchScreen) Selected() *SearchHit {
+	if ss.active >= len(ss.hits) {
+		return nil
+	}
+	return &ss.hits[ss.active]
+}
+
+func (ss *SearchScreen) Click(x, y int) *Event {
+	if i, ok := ss.ui.ItemAt(x, y).(int); ok {
+		ss.active = i
+		return ss.GetMenuEvent()
+	}
+	return nil
+}
+
+func (ss *SearchScreen) Draw() {
+	ss.ui.DrawText("Search messages of this session", titleStyle, false)
+	ss.ui.DrawText(ss.ui.typed, inputStyle, true)
+	switch {
+	case len(ss.err) > 0:
+		ss.ui.DrawText(ss.err, footerStyle, false)
+	case ss.query == nil || ss.query.Empty():
+		ss.ui.DrawText("Type words to find, from:<name>, after:2006-01-02 or before:2006-01-02", footerStyle, false)
+	case len(ss.hits) == 0:
+		ss.ui.DrawText("Nothing found", footerStyle, false)
+	case ss.total > len(ss.hits):
+		ss.ui.DrawText(fmt.Sprintf("%d found, the newest %d shown · Enter: open, Esc: back", ss.total, len(ss.hits)), footerStyle, false)
+	default:
+		ss.ui.DrawText(fmt.Sprintf("%d found · Up/Down: select, Enter: open, Esc: back", ss.total), footerStyle, false)
+	}
+	// Every hit takes two rows
+	_, h := ss.ui.tcs.Size()
+	shown := (h - ss.ui.topRows - ss.ui.bottomRows) / 2
+	first := 0
+	if ss.active >= shown {
+		first = ss.active - shown + 1
+	}
+	now := time.Now()
+	for i := first; i < len(ss.hits) && i < first+shown; i++ {
+		hit := ss.hits[i]
+		style := footerStyle
+		if i == ss.active {
+			style = menuActiveItemStyle
+		}
+		header := fmt.Sprintf("%s · %s · %s %s", hit.chat.Label(), hit.chat.SenderName(hit.msg.sender),
+			formatDay(hit.msg.ts, now), hit.msg.ts.Format("15:04"))
+		top := ss.ui.topRows
+		ss.ui.DrawText(truncate(header, ss.ui.Width()), style, false)
+		ss.ui.DrawRows(wrapLine(ss.query.highlight(hit.msg.text), ss.ui.Width())[:1], menuItemStyle)
+		ss.ui.Mark(top, ss.ui.topRows, i)
+	}
+}
+
+func init() {
+	registerCommand(&Command{
+		name:  "search",
+		usage: "[query]",
+		help:  "searches the messages all chats got in this session",
+		run: func(a *App, cl CommandLine) error {
+			a.openSearch(cl.rest)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchIndex(t *testing.T) {
+	s := NewServer(&Contacts{}, &Settings{})
+	s.address = "me"
+	c := NewChat(s)
+	c.AddMember("peer")
+	day := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	msgs := []*Message{
+		NewMessage("Gophers like Go", 0, day, "peer", true, false),
+		NewMessage("going home", 1, day.AddDate(0, 0, 1), "me", true, true),
+		NewMessage("rust and go", 2, day.AddDate(0, 0, 2), "peer", true, false),
+		NewMessage("still typing go", 3, day, "peer", false, false),
+	}
+	for _, m := range msgs {
+		s.index.Update(c, m)
+	}
+	search := func(query string) []*Message {
+		q, err := ParseSearchQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hits, _ := s.index.Search(q)
+		var found []*Message
+		for _, h := range hits {
+			found = append(found, h.msg)
+		}
+		return found
+	}
+	tests := []struct {
+		query string
+		want  []*Message
+	}{
+		{"go", []*Message{msgs[2], msgs[1], msgs[0]}},
+		{"goph", []*Message{msgs[0]}},
+		{"GO rust", []*Message{msgs[2]}},
+		{"ophers", nil},
+		{"go from:me", []*Message{msgs[1]}},
+		{"go after:2024-05-11", []*Message{msgs[2], msgs[1]}},
+		{"go before:2024-05-11", []*Message{msgs[0]}},
+		{"go after:2024-05-11 before:2024-05-12", []*Message{msgs[1]}},
+	}
+	for _, tt := range tests {
+		got := search(tt.query)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q found %d messages, want %d", tt.query, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q found %q at %d, want %q", tt.query, got[i].text, i, tt.want[i].text)
+			}
+		}
+	}
+
+	// Edits index the new words and forget the old ones
+	msgs[0].SetText("Python")
+	s.index.Update(c, msgs[0])
+	if got := search("goph"); len(got) != 0 {
+		t.Errorf("edited message still found by its old text")
+	}
+	if got := search("pyth"); len(got) != 1 {
+		t.Errorf("edited message not found by its new text")
+	}
+	msgs[2].Delete()
+	s.index.Update(c, msgs[2])
+	if got := search("rust"); len(got) != 0 {
+		t.Errorf("deleted message still found")
+	}
+	s.index.Remove(msgs[1])
+	if got := search("home"); len(got) != 0 {
+		t.Errorf("removed message still found")
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := ParseSearchQuery("Hello, World from:Alice after:2024-05-01 before:2024-06-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.words) != 2 || q.words[0] != "hello" || q.words[1] != "world" {
+		t.Errorf("words = %q", q.words)
+	}
+	if q.from != "alice" {
+		t.Errorf("from = %q", q.from)
+	}
+	if !q.after.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)) ||
+		!q.before.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("after = %v, before = %v", q.after, q.before)
+	}
+	for _, s := range []string{"after:yesterday", "before:2024-13-01", "after:"} {
+		if _, err := ParseSearchQuery(s); err == nil {
+			t.Errorf("%q parsed without an error", s)
+		}
+	}
+	if q, _ := ParseSearchQuery("  "); !q.Empty() {
+		t.Errorf("blank query isn't empty")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		query, text, want string
+	}{
+		{"go", "Go and gopher", "[Go] and [go]pher"},
+		{"go", "ago", "ago"},
+		{"per", "gopher per", "gopher [per]"},
+		{"x", "line\nx", "line [x]"},
+		{"end", "a very long message before the word at the end", "…ore the word at the [end]"},
+	}
+	for _, tt := range tests {
+		q, _ := ParseSearchQuery(tt.query)
+		got := ""
+		marked := false
+		for _, c := range q.highlight(tt.text) {
+			if m := c.attr&markMatch != 0; m != marked {
+				got += map[bool]string{true: "[", false: "]"}[m]
+				marked = m
+			}
+			got += string(c.runes)
+		}
+		if marked {
+			got += "]"
+		}
+		if got != tt.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
@@ -2,21 +2,92 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/ccding/go-stun/stun"
 	"github.com/pion/turn/v2"
 )
 
+type PacketType int
+
+const (
+	packetMessage PacketType = 0
+	packetHello   PacketType = 1
+	packetProfile PacketType = 2
+
+	packetFileOffer   PacketType = 3
+	packetFileRequest PacketType = 4
+	packetFileChunk   PacketType = 5
+	packetFileDone    PacketType = 6
+	packetFileReject  PacketType = 7
+
+	packetReceipt PacketType = 8
+	packetDelete  PacketType = 9
+
+	packetReaction PacketType = 10
+	packetMode     PacketType = 11
+)
+
+type PackedProfile struct {
+	Name   string
+	Status string
+}
+
+type PackedGroup struct {
+	ID      string
+	Title   string
+	Members []string
+}
+
+type PackedFile struct {
+	ID       string
+	Name     string
+	Size     int64
+	Checksum string
+	Offset   int64
+	Data     []byte
+	Sum      uint32
+	Error    string
+}
+
+// PackedRef points to a message by its sender and order.
+type PackedRef struct {
+	Addr  string
+	Order uint
+}
+
+type PackedReaction struct {
+	Ref    PackedRef
+	Emoji  string
+	Remove bool
+}
+
 type PackedMsg struct {
 	Msg      string
 	Order    uint
 	Finished bool
 	Addr     string
+	Type     PacketType
+	Profile  *PackedProfile
+	Group    *PackedGroup
+	File     *PackedFile
+	Status   MessageStatus
+	Revision uint
+	Reply    *PackedRef
+	Reaction *PackedReaction
+	Mode     PreviewMode
+	Typing   bool
+	// Cursor counts the runes of a draft after the caret, so that drafts
+	// from clients which don't send it have the caret at the end
+	Cursor int
 }
 
 type Server struct {
@@ -24,13 +95,27 @@ type Server struct {
 	chats          []*Chat
 	activeChat     int
 	chatsByAddress map[string]*Chat
+	chatsByID      map[string]*Chat
+	peers          map[string]*Peer
+	transfers      map[string]*Transfer
+	index          *SearchIndex
+	contacts       *Contacts
+	settings       *Settings
+	events         chan<- *Event
 	conn           net.PacketConn
 	relayConn      net.PacketConn
+	// packets decoded by the listener for the app loop
+	incoming   []*PackedMsg
+	incomingMu sync.Mutex
 }
 
-func NewServer() *Server {
-	s := Server{}
+func NewServer(contacts *Contacts, settings *Settings) *Server {
+	s := Server{contacts: contacts, settings: settings}
 	s.chatsByAddress = make(map[string]*Chat)
+	s.chatsByID = make(map[string]*Chat)
+	s.peers = make(map[string]*Peer)
+	s.transfers = make(map[string]*Transfer)
+	s.index = NewSearchIndex()
 	return &s
 }
 
@@ -38,18 +123,159 @@ func (s *Server) GetActiveChat() *Chat {
 	return s.chats[s.activeChat]
 }
 
+func (s *Server) GetPeer(addr string) *Peer {
+	p, ok := s.peers[addr]
+	if !ok {
+		p = NewPeer(addr)
+		s.peers[addr] = p
+	}
+	return p
+}
+
 func (s *Server) GetOrCreateChat(addr string) *Chat {
+	cht, created := s.getOrCreateChat(addr)
+	if created {
+		cht.Hello()
+	}
+	return cht
+}
+
+func (s *Server) getOrCreateChat(addr string) (*Chat, bool) {
 	cht, ok := s.chatsByAddress[addr]
 	if !ok {
 		cht = NewChat(s)
-		cht.SetAddress(addr)
+		cht.AddMember(addr)
 		s.chats = append(s.chats, cht)
 		s.chatsByAddress[addr] = cht
 	}
+	return cht, !ok
+}
+
+// CloseChat leaves the chat out of the chat list. Queued messages are sent
+// right away and running transfers are cancelled. Its messages are no longer
+// found by searches, a new message opens the chat again.
+func (s *Server) CloseChat(c *Chat) {
+	for _, m := range c.queued {
+		c.finishSend(m)
+	}
+	c.queued = nil
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+	}
+	for _, t := range c.transfers {
+		// Transfers that are over can't be rejected any more
+		t.Reject()
+		delete(s.transfers, t.key())
+	}
+	for i, cht := range s.chats {
+		if cht == c {
+			s.chats = append(s.chats[:i], s.chats[i+1:]...)
+			break
+		}
+	}
+	if c.IsGroup() {
+		delete(s.chatsByID, c.id)
+	} else {
+		for _, m := range c.members {
+			delete(s.chatsByAddress, m.peer.address)
+		}
+	}
+	for _, m := range c.allMessages {
+		s.index.Remove(m)
+	}
+	if s.activeChat >= len(s.chats) {
+		s.activeChat = 0
+	}
+}
+
+// CreateGroupChat starts a new group and invites every member to it.
+func (s *Server) CreateGroupChat(title string, addrs []string) *Chat {
+	cht, _ := s.getOrCreateGroupChat(&PackedGroup{
+		ID:      newID(),
+		Title:   title,
+		Members: addrs,
+	})
+	cht.Hello()
 	return cht
 }
 
+func (s *Server) getOrCreateGroupChat(g *PackedGroup) (*Chat, bool) {
+	cht, ok := s.chatsByID[g.ID]
+	if !ok {
+		cht = NewGroupChat(s, g.ID, g.Title)
+		s.chats = append(s.chats, cht)
+		s.chatsByID[g.ID] = cht
+	}
+	for _, addr := range g.Members {
+		cht.AddMember(addr)
+	}
+	return cht, !ok
+}
+
+// chatForPacket finds the chat the packet belongs to and makes sure its
+// sender is a member of the chat.
+func (s *Server) chatForPacket(p *PackedMsg) (*Chat, bool) {
+	if p.Group != nil {
+		cht, created := s.getOrCreateGroupChat(p.Group)
+		cht.AddMember(p.Addr)
+		return cht, created
+	}
+	return s.getOrCreateChat(p.Addr)
+}
+
+// requestCommit asks the app loop to finish the queued messages, so that it
+// doesn't happen while the screen is drawn.
+func (s *Server) requestCommit() {
+	s.events <- &eventCommitSends
+}
+
+func (s *Server) requestFlush() {
+	s.events <- &eventFlushPreviews
+}
+
+func (s *Server) FlushPreviews() {
+	now := time.Now()
+	for _, c := range s.chats {
+		c.flushPreview(now)
+	}
+}
+
+// AnnounceModes tells every chat about the current live preview modes.
+func (s *Server) AnnounceModes() {
+	for _, c := range s.chats {
+		c.AnnounceMode()
+	}
+}
+
+func (s *Server) CommitSends() {
+	now := time.Now()
+	for _, c := range s.chats {
+		c.commitSends(now)
+	}
+}
+
+func newID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// BroadcastProfile lets every peer know that our profile has changed.
+func (s *Server) BroadcastProfile() {
+	for _, p := range s.peers {
+		s.SendProfile(packetProfile, p.updAddr)
+	}
+}
+
+func (s *Server) SendProfile(t PacketType, addr *net.UDPAddr) error {
+	return s.sendPacket(&PackedMsg{
+		Type:    t,
+		Profile: s.settings.Profile(),
+	}, addr)
+}
+
 func (s *Server) Connect(c chan<- *Event) error {
+	s.events = c
 	// err := s.connectLocal(c)
 	err := s.connectWithTurn(c)
 	if err != nil {
@@ -59,8 +285,11 @@ func (s *Server) Connect(c chan<- *Event) error {
 	return nil
 }
 
-func (s *Server) Send(msg string, o uint, f bool, addr *net.UDPAddr) error {
-	p := &PackedMsg{msg, o, f, s.address}
+func (s *Server) sendPacket(p *PackedMsg, addr *net.UDPAddr) error {
+	if s.conn == nil || addr == nil {
+		return errors.New("not connected")
+	}
+	p.Addr = s.address
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	err := enc.Encode(p)
@@ -187,12 +416,17 @@ func (s *Server) connectWithTurn(c chan<- *Event) error {
 	return nil
 }
 
+// listen decodes the packets that arrive and hands them over to the app
+// loop, which handles them in HandlePackets. Chats, peers and transfers are
+// only ever changed there.
 func (s *Server) listen(conn net.PacketConn, c chan<- *Event) error {
-	p := make([]byte, 2048)
+	// Gob sends type definitions with every packet, so leave room for them
+	// next to a file chunk
+	p := make([]byte, 65535)
 
 	for {
 		n, remoteaddr, err := conn.ReadFrom(p)
-		log.Print("Recieve", remoteaddr, p[:n])
+		log.Print("Recieve ", remoteaddr, " ", n, " bytes")
 		if err != nil {
 			continue
 		}
@@ -203,11 +437,59 @@ func (s *Server) listen(conn net.PacketConn, c chan<- *Event) error {
 		if err != nil {
 			continue
 		}
+		s.incomingMu.Lock()
+		s.incoming = append(s.incoming, &p)
+		s.incomingMu.Unlock()
+		c <- &eventPackets
+	}
+}
 
-		addr := remoteaddr.String()
-		log.Print("Recieve", addr, p)
-		cht := s.GetOrCreateChat(p.Addr)
-		cht.AddReceivedMessage(p, addr)
-		c <- &eventUpdateChats
+// HandlePackets applies the packets received since the last call.
+func (s *Server) HandlePackets() {
+	s.incomingMu.Lock()
+	packets := s.incoming
+	s.incoming = nil
+	s.incomingMu.Unlock()
+	for _, p := range packets {
+		s.handlePacket(p)
+	}
+}
+
+func (s *Server) handlePacket(p *PackedMsg) {
+	// File chunks would fill the log
+	log.Print("Recieve ", p.Addr, " type ", p.Type, " order ", p.Order)
+	peer := s.GetPeer(p.Addr)
+	if p.Type == packetProfile {
+		// Profiles belong to the peer, not to a chat
+		peer.SetProfile(p.Profile)
+		return
+	}
+	cht, created := s.chatForPacket(p)
+	if created && (p.Type != packetHello || cht.IsGroup()) {
+		cht.Hello()
+	}
+	if m := cht.GetMember(p.Addr); m != nil {
+		m.previewMode = p.Mode
+	}
+	switch p.Type {
+	case packetHello:
+		peer.SetProfile(p.Profile)
+		s.SendProfile(packetProfile, peer.updAddr)
+	case packetFileOffer, packetFileRequest, packetFileChunk, packetFileDone, packetFileReject:
+		s.handleFilePacket(p, cht, peer)
+	case packetReceipt:
+		if m := cht.GetMember(p.Addr); m != nil {
+			cht.SetReceipt(*p, m)
+		}
+	case packetReaction:
+		cht.SetReaction(*p)
+	case packetDelete:
+		if m := cht.GetMember(p.Addr); m != nil {
+			cht.DeleteReceived(*p, m)
+		}
+	default:
+		if m := cht.GetMember(p.Addr); m != nil {
+			cht.AddReceivedMessage(*p, m)
+		}
 	}
 }
@@ -0,0 +1,36 @@
+package main
+
+const settingsFile = "settings.json"
+
+type Settings struct {
+	Name                string
+	Status              string
+	DisableReadReceipts bool
+	UndoSendSeconds     int
+	PreviewMode         PreviewMode
+	SharedInputHistory  bool
+	Theme               string
+	Notifications       NotifyMode
+	Timestamps          TimestampMode
+	RawText             bool
+}
+
+func LoadSettings() (*Settings, error) {
+	s := Settings{}
+	err := loadJSON(settingsFile, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Settings) Save() error {
+	return saveJSON(settingsFile, s)
+}
+
+func (s *Settings) Profile() *PackedProfile {
+	return &PackedProfile{
+		Name:   s.Name,
+		Status: s.Status,
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	themesDir    = "themes"
+	defaultTheme = "dark"
+)
+
+// StyleSpec is a style as it is written in theme files. Colors are tcell
+// color names like "navy" or "#rrggbb", empty means the terminal default.
+type StyleSpec struct {
+	Fg        string `json:",omitempty"`
+	Bg        string `json:",omitempty"`
+	Bold      bool   `json:",omitempty"`
+	Italic    bool   `json:",omitempty"`
+	Underline bool   `json:",omitempty"`
+}
+
+type Theme struct {
+	Input          StyleSpec
+	Title          StyleSpec
+	Footer         StyleSpec
+	MenuItem       StyleSpec
+	MenuActiveItem StyleSpec
+	ReceivedMsg    StyleSpec
+	MyMsg          StyleSpec
+}
+
+var builtinThemes = map[string]Theme{
+	"dark": {
+		Input:          StyleSpec{Fg: "fuchsia"},
+		Title:          StyleSpec{Fg: "white", Bg: "navy", Bold: true},
+		Footer:         StyleSpec{Fg: "silver", Bg: "navy"},
+		MenuActiveItem: StyleSpec{Fg: "white", Bg: "purple"},
+		ReceivedMsg:    StyleSpec{Fg: "yellow"},
+		MyMsg:          StyleSpec{Fg: "white"},
+	},
+	"light": {
+		Input:          StyleSpec{Fg: "purple"},
+		Title:          StyleSpec{Fg: "white", Bg: "blue", Bold: true},
+		Footer:         StyleSpec{Fg: "black", Bg: "silver"},
+		MenuItem:       StyleSpec{Fg: "black"},
+		MenuActiveItem: StyleSpec{Fg: "white", Bg: "purple"},
+		ReceivedMsg:    StyleSpec{Fg: "navy"},
+		MyMsg:          StyleSpec{Fg: "black"},
+	},
+	"high-contrast": {
+		Input:          StyleSpec{Fg: "white", Bg: "black", Bold: true},
+		Title:          StyleSpec{Fg: "black", Bg: "white", Bold: true},
+		Footer:         StyleSpec{Fg: "black", Bg: "white"},
+		MenuItem:       StyleSpec{Fg: "white", Bg: "black"},
+		MenuActiveItem: StyleSpec{Fg: "black", Bg: "yellow", Bold: true},
+		ReceivedMsg:    StyleSpec{Fg: "yellow", Bg: "black"},
+		MyMsg:          StyleSpec{Fg: "white", Bg: "black", Bold: true},
+	},
+	// the colors livechat always had
+	"classic": {
+		Input:          StyleSpec{Fg: "pink"},
+		Title:          StyleSpec{Bg: "blue"},
+		Footer:         StyleSpec{Bg: "blue"},
+		MenuActiveItem: StyleSpec{Fg: "white", Bg: "purple"},
+		ReceivedMsg:    StyleSpec{Fg: "black", Bg: "yellow"},
+		MyMsg:          StyleSpec{Fg: "white", Bg: "black"},
+	},
+}
+
+// LoadTheme returns a built-in theme or the one in themes/<name>.json in
+// the config directory. Styles missing from a theme file are taken from
+// the default theme.
+func LoadTheme(name string) (*Theme, error) {
+	if len(name) == 0 {
+		name = defaultTheme
+	}
+	if t, ok := builtinThemes[name]; ok {
+		return &t, nil
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("bad theme name %q", name)
+	}
+	dir, err := storagePath(themesDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no theme %q, the built-in ones are %s", name, strings.Join(themeNames(), ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := builtinThemes[defaultTheme]
+	if err = json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("theme %s: %w", name, err)
+	}
+	for _, s := range []StyleSpec{t.Input, t.Title, t.Footer, t.MenuItem, t.MenuActiveItem, t.ReceivedMsg, t.MyMsg} {
+		for _, c := range []string{s.Fg, s.Bg} {
+			if len(c) > 0 && c != "default" && tcell.GetColor(c) == tcell.ColorDefault {
+				return nil, fmt.Errorf("theme %s: unknown color %q", name, c)
+			}
+		}
+	}
+	return &t, nil
+}
+
+func themeNames() []string {
+	var names []string
+	for n := range builtinThemes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyTheme sets the styles everything is drawn with. Terminals with less
+// than 256 colors get the nearest ones they have, and terminals without
+// colors or with NO_COLOR set get bold and reverse video instead.
+func (ui *UI) ApplyTheme(t *Theme) {
+	colors := ui.tcs.Colors()
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		colors = 0
+	}
+	inputStyle = t.Input.style(colors)
+	titleStyle = t.Title.style(colors)
+	footerStyle = t.Footer.style(colors)
+	menuItemStyle = t.MenuItem.style(colors)
+	menuActiveItemStyle = t.MenuActiveItem.style(colors)
+	receivedMsgStyle = t.ReceivedMsg.style(colors)
+	myMsgStyle = t.MyMsg.style(colors)
+	if colors < 8 {
+		// Without colors our messages and the selection still have to
+		// stand out
+		myMsgStyle = myMsgStyle.Bold(true)
+		menuActiveItemStyle = menuActiveItemStyle.Reverse(true)
+	}
+}
+
+func (s StyleSpec) style(colors int) tcell.Style {
+	st := tcell.StyleDefault.Bold(s.Bold).Italic(s.Italic).Underline(s.Underline)
+	fg, bg := specColor(s.Fg), specColor(s.Bg)
+	if colors < 8 {
+		// Highlighted styles are told apart by reverse video
+		return st.Reverse(bg != tcell.ColorReset)
+	}
+	if colors < 256 {
+		palette := make([]tcell.Color, colors)
+		for i := range palette {
+			palette[i] = tcell.PaletteColor(i)
+		}
+		if fg != tcell.ColorReset {
+			fg = tcell.FindColor(fg, palette)
+		}
+		if bg != tcell.ColorReset {
+			bg = tcell.FindColor(bg, palette)
+		}
+		if fg == bg && bg != tcell.ColorReset {
+			fg = contrastColor(bg)
+		}
+	}
+	return st.Foreground(fg).Background(bg)
+}
+
+func specColor(name string) tcell.Color {
+	if len(name) == 0 || name == "default" {
+		return tcell.ColorReset
+	}
+	c := tcell.GetColor(name)
+	if c == tcell.ColorDefault {
+		return tcell.ColorReset
+	}
+	return c
+}
+
+// contrastColor is black or white, whichever is easier to read on c.
+func contrastColor(c tcell.Color) tcell.Color {
+	r, g, b := c.RGB()
+	if r*299+g*587+b*114 > 128000 {
+		return tcell.ColorBlack
+	}
+	return tcell.ColorWhite
+}
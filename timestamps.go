@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampMode is how the time of messages is shown in the message list.
+type TimestampMode int
+
+const (
+	timestampAbsolute TimestampMode = 0
+	timestampRelative TimestampMode = 1
+	timestampOff      TimestampMode = 2
+)
+
+// Consecutive messages of a sender sent within this time share one header.
+const groupGap = 5 * time.Minute
+
+var timestampModeKeys = map[string]TimestampMode{
+	"absolute": timestampAbsolute,
+	"relative": timestampRelative,
+	"off":      timestampOff,
+}
+
+func timestampModeKey(mode TimestampMode) string {
+	for k, m := range timestampModeKeys {
+		if m == mode {
+			return k
+		}
+	}
+	return "absolute"
+}
+
+// formatTimestamp returns the time like "14:05" or "5m ago". Day separators
+// already tell the date, so absolute times don't repeat it.
+func formatTimestamp(t time.Time, mode TimestampMode, now time.Time) string {
+	switch mode {
+	case timestampRelative:
+		d := now.Sub(t)
+		if d < time.Minute {
+			return "just now"
+		}
+		return formatDuration(d) + " ago"
+	case timestampAbsolute:
+		return t.Format("15:04")
+	}
+	return ""
+}
+
+// formatDuration rounds to the largest unit: 12s, 5m, 3h or 2d.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// formatDay is the text of the separator before the first message of a day.
+func formatDay(t time.Time, now time.Time) string {
+	switch {
+	case sameDay(t, now):
+		return "Today"
+	case sameDay(t, now.AddDate(0, 0, -1)):
+		return "Yesterday"
+	case t.Year() == now.Year():
+		return t.Format("Monday, 2 January")
+	}
+	return t.Format("Monday, 2 January 2006")
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
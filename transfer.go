@@ -0,0 +1,570 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// A chunk has to fit into a single datagram together with the header
+	chunkSize      = 1024
+	transferWindow = 16
+	transferRetry  = 2 * time.Second
+	transferShown  = 5
+)
+
+type TransferState int
+
+const (
+	transferOffered  TransferState = 0
+	transferPending  TransferState = 1
+	transferActive   TransferState = 2
+	transferDone     TransferState = 3
+	transferRejected TransferState = 4
+	transferFailed   TransferState = 5
+)
+
+// Transfer is a file moving between us and a single peer. The receiver
+// drives it: it asks for a window of chunks starting at the first byte it
+// does not have yet, and asks again if the window doesn't complete in time.
+type Transfer struct {
+	mu       sync.Mutex
+	id       string
+	name     string
+	size     int64
+	checksum string
+	incoming bool
+	peer     *Peer
+	chat     *Chat
+	path     string
+	state    TransferState
+	offset   int64
+	window   map[int64]bool
+	file     *os.File
+	updated  time.Time
+	retries  int
+	err      string
+}
+
+func (t *Transfer) key() string {
+	return t.peer.address + "/" + t.id
+}
+
+func (t *Transfer) packed() *PackedFile {
+	return &PackedFile{ID: t.id}
+}
+
+func (t *Transfer) send(pt PacketType, f *PackedFile) {
+	t.chat.sendTo(&PackedMsg{Type: pt, File: f}, t.peer)
+}
+
+func (c *Chat) SendFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		return errors.New(path + " is a directory")
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	for _, m := range c.members {
+		t := &Transfer{
+			id:       newID(),
+			name:     filepath.Base(path),
+			size:     st.Size(),
+			checksum: sum,
+			peer:     m.peer,
+			chat:     c,
+			path:     path,
+			state:    transferOffered,
+			updated:  time.Now(),
+		}
+		c.addTransfer(t)
+		t.send(packetFileOffer, &PackedFile{
+			ID:       t.id,
+			Name:     t.name,
+			Size:     t.size,
+			Checksum: t.checksum,
+		})
+	}
+	return nil
+}
+
+func (c *Chat) addTransfer(t *Transfer) {
+	c.transfers = append(c.transfers, t)
+	c.server.transfers[t.key()] = t
+}
+
+// GetTransfer finds a transfer by the number shown in the chat. Zero means
+// the oldest one waiting for an answer.
+func (c *Chat) GetTransfer(n int) *Transfer {
+	if n > 0 {
+		if n > len(c.transfers) {
+			return nil
+		}
+		return c.transfers[n-1]
+	}
+	for _, t := range c.transfers {
+		if t.state == transferPending {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleFilePacket(p *PackedMsg, c *Chat, peer *Peer) {
+	if p.File == nil {
+		return
+	}
+	if p.Type == packetFileOffer {
+		// The checksum names the part file, so it must not be a path
+		if !validChecksum(p.File.Checksum) {
+			c.sendTo(&PackedMsg{Type: packetFileReject, File: &PackedFile{
+				ID:    p.File.ID,
+				Error: "invalid checksum",
+			}}, peer)
+			return
+		}
+		t := &Transfer{
+			id:       p.File.ID,
+			name:     safeFileName(p.File.Name),
+			size:     p.File.Size,
+			checksum: p.File.Checksum,
+			incoming: true,
+			peer:     peer,
+			chat:     c,
+			state:    transferPending,
+			updated:  time.Now(),
+		}
+		if _, ok := s.transfers[t.key()]; !ok {
+			c.addTransfer(t)
+		}
+		return
+	}
+	t, ok := s.transfers[peer.address+"/"+p.File.ID]
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch p.Type {
+	case packetFileRequest:
+		t.sendWindow(p.File.Offset)
+	case packetFileChunk:
+		t.receiveChunk(p.File)
+	case packetFileDone:
+		t.offset = t.size
+		t.state = transferDone
+	case packetFileReject:
+		t.closeFile()
+		t.state = transferRejected
+		if len(p.File.Error) > 0 {
+			t.state = transferFailed
+			t.err = p.File.Error
+		}
+	}
+}
+
+// validChecksum reports whether the checksum is a hex SHA-256 like the one
+// SendFile computes.
+func validChecksum(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	for _, r := range sum {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Accept starts an incoming transfer. A partial download of the same file
+// left by an interrupted transfer is resumed.
+func (t *Transfer) Accept() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.incoming || t.state != transferPending {
+		return errors.New("nothing to accept")
+	}
+	dir, err := downloadsDir()
+	if err != nil {
+		return err
+	}
+	t.path = filepath.Join(dir, "."+t.checksum+".part")
+	t.file, err = os.OpenFile(t.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	st, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	t.offset = resumeOffset(st.Size(), t.size)
+	t.state = transferActive
+	if t.offset >= t.size {
+		t.finish()
+		return nil
+	}
+	t.request()
+	go t.watch()
+	return nil
+}
+
+// resumeOffset is where a download of size bytes goes on with a partial file
+// of partSize bytes. Chunks are written out of order only inside the last
+// window, so everything before it is known to be complete.
+func resumeOffset(partSize, size int64) int64 {
+	offset := (partSize - transferWindow*chunkSize) / chunkSize * chunkSize
+	if offset < 0 || offset > size {
+		return 0
+	}
+	return offset
+}
+
+// Reject declines an offer or cancels a running transfer.
+func (t *Transfer) Reject() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch t.state {
+	case transferOffered, transferPending, transferActive:
+	default:
+		return errors.New("transfer is already over")
+	}
+	t.closeFile()
+	t.state = transferRejected
+	t.send(packetFileReject, t.packed())
+	return nil
+}
+
+func (t *Transfer) request() {
+	t.window = make(map[int64]bool)
+	t.updated = time.Now()
+	f := t.packed()
+	f.Offset = t.offset
+	t.send(packetFileRequest, f)
+}
+
+// watch asks for the current window again while it doesn't complete. The
+// delay grows so a peer that went away isn't flooded, and the transfer
+// resumes as soon as the peer answers again.
+func (t *Transfer) watch() {
+	for {
+		time.Sleep(transferRetry / 4)
+		t.mu.Lock()
+		if t.state != transferActive {
+			t.mu.Unlock()
+			return
+		}
+		retries := t.retries
+		if retries > 4 {
+			retries = 4
+		}
+		if time.Since(t.updated) > transferRetry<<retries {
+			t.retries++
+			t.request()
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *Transfer) sendWindow(offset int64) {
+	switch t.state {
+	case transferOffered, transferActive:
+	default:
+		return
+	}
+	t.state = transferActive
+	t.offset = offset
+	t.updated = time.Now()
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		t.fail(err)
+		return
+	}
+	defer f.Close()
+	buf := make([]byte, chunkSize)
+	for i := 0; i < transferWindow && offset < t.size; i++ {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			t.fail(err)
+			return
+		}
+		if n == 0 {
+			break
+		}
+		data := append([]byte(nil), buf[:n]...)
+		t.send(packetFileChunk, &PackedFile{
+			ID:     t.id,
+			Offset: offset,
+			Data:   data,
+			Sum:    crc32.ChecksumIEEE(data),
+		})
+		offset += int64(n)
+	}
+}
+
+func (t *Transfer) receiveChunk(f *PackedFile) {
+	if t.state != transferActive {
+		return
+	}
+	end := t.offset + transferWindow*chunkSize
+	if f.Offset < t.offset || f.Offset >= end || f.Offset%chunkSize != 0 {
+		return
+	}
+	if crc32.ChecksumIEEE(f.Data) != f.Sum {
+		log.Print("Broken chunk ", t.name, " ", f.Offset)
+		return
+	}
+	if _, err := t.file.WriteAt(f.Data, f.Offset); err != nil {
+		t.fail(err)
+		return
+	}
+	t.window[f.Offset] = true
+	t.updated = time.Now()
+	t.retries = 0
+
+	if end > t.size {
+		end = t.size
+	}
+	for o := t.offset; o < end; o += chunkSize {
+		if !t.window[o] {
+			return
+		}
+	}
+	t.offset = end
+	if t.offset >= t.size {
+		t.finish()
+	} else {
+		t.request()
+	}
+}
+
+// finish checks the whole file and moves it next to the other downloads.
+func (t *Transfer) finish() {
+	if err := t.file.Truncate(t.size); err != nil {
+		t.fail(err)
+		return
+	}
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		t.fail(err)
+		return
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, t.file); err != nil {
+		t.fail(err)
+		return
+	}
+	t.closeFile()
+	if hex.EncodeToString(h.Sum(nil)) != t.checksum {
+		os.Remove(t.path)
+		t.fail(errors.New("checksum mismatch"))
+		return
+	}
+	dest := uniqueFileName(filepath.Join(filepath.Dir(t.path), t.name))
+	if err := os.Rename(t.path, dest); err != nil {
+		t.fail(err)
+		return
+	}
+	t.path = dest
+	t.state = transferDone
+	t.send(packetFileDone, t.packed())
+}
+
+func (t *Transfer) fail(err error) {
+	log.Print("Transfer ", t.name, " ", err)
+	t.closeFile()
+	t.state = transferFailed
+	t.err = err.Error()
+	f := t.packed()
+	f.Error = t.err
+	t.send(packetFileReject, f)
+}
+
+func (t *Transfer) closeFile() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+func (t *Transfer) progress() float64 {
+	if t.size == 0 {
+		return 1
+	}
+	return float64(t.offset) / float64(t.size)
+}
+
+func (t *Transfer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dir := "↑"
+	if t.incoming {
+		dir = "↓"
+	}
+	s := fmt.Sprintf("%s %s %s ", dir, t.name, formatSize(t.size))
+	switch t.state {
+	case transferOffered:
+		return s + "waiting for " + t.peer.Name(t.chat.server.contacts)
+	case transferPending:
+		return s + "/accept or /reject"
+	case transferActive:
+		return s + progressBar(t.progress(), 20)
+	case transferDone:
+		if t.incoming {
+			return s + "saved to " + t.path
+		}
+		return s + "delivered"
+	case transferRejected:
+		return s + "rejected"
+	}
+	return s + "failed: " + t.err
+}
+
+func progressBar(p float64, width int) string {
+	n := int(p * float64(width))
+	return fmt.Sprintf("[%s%s] %3d%%",
+		strings.Repeat("#", n),
+		strings.Repeat(".", width-n),
+		int(p*100),
+	)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func safeFileName(name string) string {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == string(filepath.Separator) {
+		return "file"
+	}
+	if strings.HasPrefix(name, ".") {
+		return "file" + name
+	}
+	return name
+}
+
+func uniqueFileName(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+func init() {
+	registerCommand(&Command{
+		name:  "send-file",
+		usage: "<path>",
+		help:  "offers a file to the members of the chat",
+		run: func(a *App, cl CommandLine) error {
+			if len(cl.rest) == 0 {
+				return usageError(commands["send-file"])
+			}
+			return a.activeChat.SendFile(cl.rest)
+		},
+		complete: completePath,
+		wholeArg: true,
+	})
+	for _, name := range []string{"accept", "reject"} {
+		accept := name == "accept"
+		registerCommand(&Command{
+			name:  name,
+			usage: "[number]",
+			help:  name + "s the file offered with the number, or the oldest one waiting",
+			run: func(a *App, cl CommandLine) error {
+				n := 0
+				if len(cl.args) > 0 {
+					n, _ = strconv.Atoi(cl.args[0])
+				}
+				t := a.activeChat.GetTransfer(n)
+				if t == nil {
+					return errors.New("no such file transfer")
+				}
+				if accept {
+					return t.Accept()
+				}
+				return t.Reject()
+			},
+			complete: completeTransfer,
+		})
+	}
+}
+
+// completePath lists the files starting with the last argument, directories
+// end with a slash.
+func completePath(a *App, word string) []string {
+	// The directory is kept as it was typed, so the names still start with
+	// the word
+	dir := word[:strings.LastIndex(word, "/")+1]
+	read := dir
+	if len(read) == 0 {
+		read = "."
+	}
+	entries, err := os.ReadDir(read)
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, e := range entries {
+		name := dir + e.Name()
+		if !strings.HasPrefix(name, word) {
+			continue
+		}
+		if e.IsDir() {
+			name += "/"
+		} else if e.Type()&os.ModeSymlink != 0 {
+			if st, err := os.Stat(name); err == nil && st.IsDir() {
+				name += "/"
+			}
+		}
+		found = append(found, name)
+	}
+	return found
+}
+
+// completeTransfer lists the numbers of the transfers waiting for an answer.
+func completeTransfer(a *App, word string) []string {
+	var found []string
+	for i, t := range a.activeChat.transfers {
+		if t.state == transferPending {
+			found = append(found, strconv.Itoa(i+1))
+		}
+	}
+	return found
+}
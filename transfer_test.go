@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestValidChecksum(t *testing.T) {
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("file")))
+	tests := []struct {
+		sum  string
+		want bool
+	}{
+		{sum, true},
+		{"", false},
+		{sum[1:], false},
+		{sum + "0", false},
+		{"A" + sum[1:], false},
+		{"g" + sum[1:], false},
+		{"../" + sum[3:], false},
+	}
+	for _, tt := range tests {
+		if got := validChecksum(tt.sum); got != tt.want {
+			t.Errorf("validChecksum(%q) = %v, want %v", tt.sum, got, tt.want)
+		}
+	}
+}
+
+func TestResumeOffset(t *testing.T) {
+	window := int64(transferWindow * chunkSize)
+	tests := []struct {
+		partSize, size int64
+		want           int64
+	}{
+		{0, 5000, 0},
+		{window - 1, 100000, 0},
+		{window, 100000, 0},
+		{window + chunkSize, 100000, chunkSize},
+		{window + chunkSize + 500, 100000, chunkSize},
+		{50000, 100000, 32 * chunkSize},
+		{100000, 100000, 81 * chunkSize},
+		// a part file bigger than the file isn't this download
+		{200000, 100000, 0},
+	}
+	for _, tt := range tests {
+		if got := resumeOffset(tt.partSize, tt.size); got != tt.want {
+			t.Errorf("resumeOffset(%d, %d) = %d, want %d", tt.partSize, tt.size, got, tt.want)
+		}
+	}
+}
@@ -1,29 +1,26 @@
 package main
 
 import (
+	"encoding/base64"
+	"fmt"
 	"log"
-	"math"
-	"unicode/utf8"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/gdamore/tcell/v2"
-	"github.com/mattn/go-runewidth"
 )
 
-var keyMap = map[int16]string{
-	13:  "Enter",
-	257: "Up",
-	258: "Down",
-	256: "Rune",
-	27:  "Esc",
-}
-
-var inputStyle = tcell.StyleDefault.Foreground(tcell.ColorPink).Background(tcell.ColorReset)
-var titleStyle = tcell.StyleDefault.Foreground(tcell.ColorReset).Background(tcell.ColorBlue)
-var footerStyle = tcell.StyleDefault.Foreground(tcell.ColorReset).Background(tcell.ColorBlue)
-var menuItemStyle = tcell.StyleDefault.Foreground(tcell.ColorReset).Background(tcell.ColorReset)
-var menuActiveItemStyle = tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorPurple)
-var receivedMsgStyle = tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow)
-var myMsgStyle = tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack)
+// Styles of the current theme, set by ApplyTheme
+var (
+	inputStyle          tcell.Style
+	titleStyle          tcell.Style
+	footerStyle         tcell.Style
+	menuItemStyle       tcell.Style
+	menuActiveItemStyle tcell.Style
+	receivedMsgStyle    tcell.Style
+	myMsgStyle          tcell.Style
+)
 
 type UI struct {
 	tcs          tcell.Screen
@@ -31,10 +28,40 @@ type UI struct {
 	getKeysMap   func() *KeyEventMap
 	enableVMenu  bool
 	enableTyping bool
+	multiline    bool
 	topRows      int
 	bottomRows   int
 	typed        string
-	runes        []rune
+	editor       Editor
+	status       string
+	overlay      Screen
+	// text of a bracketed paste while it arrives
+	pasting bool
+	paste   strings.Builder
+	// the last paste that went into the input
+	pasted string
+	// what was drawn on the screen rows, for clicks
+	items   []clickRegion
+	buttons tcell.ButtonMask
+	// keys of a chord pressed so far
+	chord string
+	// the part of the screen the text is drawn in, see SetViewport
+	left  int
+	width int
+	// rows at the bottom taken by the status for every viewport
+	reservedRows int
+	// links are drawn as OSC 8 hyperlinks
+	hyperlinks bool
+	// the link cells of the frame
+	links []linkCell
+	// escape sequences tcell doesn't know are written between its frames
+	out sync.Mutex
+}
+
+// clickRegion is a rectangle of the screen where an item was drawn.
+type clickRegion struct {
+	left, right, top, bottom int
+	item                     interface{}
 }
 
 func NewUI(f func() *KeyEventMap) (*UI, error) {
@@ -61,7 +88,10 @@ func (ui *UI) init() error {
 	if err = ui.tcs.Init(); err != nil {
 		return err
 	}
+	ui.tcs.EnablePaste()
+	ui.tcs.EnableMouse()
 	ui.tcs.Clear()
+	ui.hyperlinks = hyperlinksSupported()
 	return nil
 }
 
@@ -69,9 +99,14 @@ func (ui *UI) SetScreen(s Screen, typing bool, vMenu bool) {
 	ui.screen = s
 	ui.enableTyping = typing
 	ui.enableVMenu = vMenu
-	ui.typed = ""
-	ui.runes = []rune{}
+	ui.multiline = false
+	ui.SetTyped("")
 	ui.topRows = 0
+	ui.status = ""
+	ui.overlay = nil
+	if si, ok := s.(ScreenWithInput); ok {
+		ui.SetTyped(si.InitialInput())
+	}
 }
 
 func (ui *UI) EnableTyping() {
@@ -82,9 +117,40 @@ func (ui *UI) DisableTyping() {
 	ui.enableTyping = false
 }
 
+// EnableMultiline lets Alt+Enter, Shift+Enter and Ctrl+J start a new line
+// in the input instead of leaving Enter as the only way to end it.
+func (ui *UI) EnableMultiline() {
+	ui.multiline = true
+}
+
+func (ui *UI) DisableMultiline() {
+	ui.multiline = false
+}
+
 func (ui *UI) ClearTyped() {
-	ui.typed = ""
-	ui.runes = []rune{}
+	ui.SetTyped("")
+}
+
+func (ui *UI) SetTyped(t string) {
+	ui.editor.SetText(t)
+	ui.typed = t
+	ui.pasted = ""
+}
+
+// CursorFromEnd is the position of the cursor counted in runes back from
+// the end of the typed text.
+func (ui *UI) CursorFromEnd() int {
+	return ui.editor.CursorFromEnd()
+}
+
+// SetOverlay draws s on top of the current screen, nil removes it.
+func (ui *UI) SetOverlay(s Screen) {
+	ui.overlay = s
+}
+
+// SetStatus shows a one-line notice at the bottom of the current screen.
+func (ui *UI) SetStatus(s string) {
+	ui.status = s
 }
 
 func (ui *UI) EnableVMenu() {
@@ -99,87 +165,339 @@ func (ui *UI) Draw() {
 	ui.tcs.Clear()
 	ui.topRows = 0
 	ui.bottomRows = 0
+	ui.left = 0
+	ui.width, _ = ui.tcs.Size()
+	ui.items = nil
+	ui.links = nil
+	if len(ui.status) > 0 {
+		ui.DrawTextBottom(ui.status, footerStyle, false)
+	}
+	if len(ui.chord) > 0 {
+		ui.DrawTextBottom(ui.chord+" …", footerStyle, false)
+	}
+	ui.reservedRows = ui.bottomRows
 	ui.screen.Draw()
+	ui.SetViewport(0, 0)
+	if ui.overlay != nil {
+		ui.overlay.Draw()
+	}
+	ui.out.Lock()
 	ui.tcs.Show()
+	ui.out.Unlock()
+	if links := ui.hyperlinkEscapes(); len(links) > 0 {
+		ui.writeEscape("%s", links)
+	}
 }
 
-func (ui *UI) Listen(c chan<- *Event) {
+// writeEscape sends an escape sequence to the terminal, never in the middle
+// of a frame.
+func (ui *UI) writeEscape(format string, a ...interface{}) {
+	ui.out.Lock()
+	defer ui.out.Unlock()
+	fmt.Fprintf(os.Stdout, format, a...)
+}
+
+// Listen passes the events of the terminal on to the app loop, which
+// handles them with HandleEvent. The UI is only ever changed there.
+func (ui *UI) Listen(c chan<- tcell.Event) {
 	for {
-		// Process event
 		ev := ui.tcs.PollEvent()
-		// log.Printf("%t", ev)
-		switch ev := ev.(type) {
-		case *tcell.EventResize:
-			ui.tcs.Sync()
-		case *tcell.EventKey:
-			if ev.Key() == tcell.KeyCtrlC {
-				panic("err")
-			}
-			log.Print("Input | ", ev.Name(), " | ", ev.Key(), " | ", ev.Rune())
-			if ui.enableTyping {
-				if ev.Key() == tcell.KeyRune {
-					ui.typed += string(ev.Rune())
-					ui.runes = append(ui.runes, ev.Rune())
-					log.Print(ui.typed)
-					log.Print(ui.runes)
-					ui.Draw()
-					c <- &eventTyping
-					continue
-				} else if ev.Key() == tcell.KeyBackspace2 || ev.Key() == tcell.KeyBackspace {
-					if len(ui.typed) > 0 {
-						ui.typed = removeLastRune(ui.typed)
-						ui.Draw()
-					}
-					c <- &eventTyping
-					continue
-				}
+		if ev == nil {
+			// The screen is finished
+			return
+		}
+		c <- ev
+	}
+}
+
+// HandleEvent applies a terminal event to the input and the screen and
+// returns the app event it stands for, if any.
+func (ui *UI) HandleEvent(ev tcell.Event) *Event {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		ui.tcs.Sync()
+		return &eventRedraw
+	case *tcell.EventMouse:
+		return ui.mouse(ev)
+	case *tcell.EventPaste:
+		if ev.Start() {
+			ui.pasting = true
+			ui.paste.Reset()
+			return nil
+		}
+		ui.pasting = false
+		if ui.enableTyping && ui.paste.Len() > 0 {
+			ui.insertPaste(ui.paste.String())
+			return &eventPaste
+		}
+	case *tcell.EventKey:
+		if ui.pasting {
+			// Keys of a paste never trigger actions
+			switch ev.Key() {
+			case tcell.KeyRune:
+				ui.paste.WriteRune(ev.Rune())
+			case tcell.KeyEnter, tcell.KeyLF:
+				ui.paste.WriteRune('\n')
+			case tcell.KeyTab:
+				ui.paste.WriteRune('\t')
 			}
-			if ui.enableVMenu {
-				switch s := ui.screen.(type) {
-				case ScreenWithMenu:
-					switch ev.Key() {
-					case tcell.KeyDown:
-						s.MenuDown()
-						ui.Draw()
-						continue
-					case tcell.KeyUp:
-						s.MenuUp()
-						ui.Draw()
-						continue
-					case tcell.KeyEnter:
-						e := s.GetMenuEvent()
-						if e != nil {
-							c <- e
-						}
-						continue
-					}
+			return nil
+		}
+		if ev.Key() == tcell.KeyCtrlC {
+			panic("err")
+		}
+		log.Print("Input | ", ev.Name(), " | ", ev.Key(), " | ", ev.Rune())
+		if ui.enableTyping && len(ui.chord) == 0 && ui.edit(ev) {
+			ui.typed = ui.editor.Text()
+			return &eventTyping
+		}
+		if ui.enableVMenu && len(ui.chord) == 0 {
+			switch s := ui.screen.(type) {
+			case ScreenWithMenu:
+				switch ev.Key() {
+				case tcell.KeyDown:
+					s.MenuDown()
+					return &eventRedraw
+				case tcell.KeyUp:
+					s.MenuUp()
+					return &eventRedraw
+				case tcell.KeyEnter:
+					return s.GetMenuEvent()
 				}
 			}
-			e := ui.findInputEvent(ev)
-			if e != nil {
-				log.Print("Input Event ", e.event.name)
-				c <- e.event
-			}
+		}
+		chord := ui.chord
+		e := ui.findInputEvent(ev)
+		if e != nil {
+			log.Print("Input Event ", e.event.name)
+			return e.event
+		} else if chord != ui.chord {
+			return &eventRedraw
 		}
 	}
+	return nil
 }
 
-func removeLastRune(s string) string {
-	_, n := utf8.DecodeLastRuneInString(s)
-	return s[:len(s)-n]
+// mouse passes clicks to the overlay or the screen and the wheel to the
+// screen. A click is taken when the button goes down, not while it is held.
+func (ui *UI) mouse(ev *tcell.EventMouse) *Event {
+	buttons := ev.Buttons()
+	pressed := buttons &^ ui.buttons
+	ui.buttons = buttons
+	x, y := ev.Position()
+	switch {
+	case buttons&tcell.WheelUp != 0, buttons&tcell.WheelDown != 0:
+		s, ok := ui.screen.(ScreenWithScroll)
+		if !ok || ui.overlay != nil {
+			return nil
+		}
+		if buttons&tcell.WheelUp != 0 {
+			s.Scroll(1)
+		} else {
+			s.Scroll(-1)
+		}
+		return &eventRedraw
+	case pressed&tcell.Button1 != 0:
+		target := ui.screen
+		if ui.overlay != nil {
+			target = ui.overlay
+		}
+		if s, ok := target.(ScreenWithMouse); ok {
+			return s.Click(x, y)
+		}
+	}
+	return nil
 }
 
-func (ui *UI) findInputEvent(ev *tcell.EventKey) *InputEvent {
-	k, ok := keyMap[int16(ev.Key())]
-	if !ok {
-		return nil
+// SetViewport makes the text drawn next go to the columns from x and w
+// wide, with the rows counted from the edges again. A zero width means up
+// to the right edge of the screen.
+func (ui *UI) SetViewport(x, w int) {
+	sw, _ := ui.tcs.Size()
+	if w <= 0 || x+w > sw {
+		w = sw - x
 	}
+	ui.left = x
+	ui.width = w
+	ui.topRows = 0
+	ui.bottomRows = ui.reservedRows
+}
+
+// Width is the width of the current viewport.
+func (ui *UI) Width() int {
+	return ui.width
+}
+
+// Mark makes clicks on the rows from top up to bottom of the current
+// viewport find the item.
+func (ui *UI) Mark(top, bottom int, item interface{}) {
+	ui.items = append(ui.items, clickRegion{ui.left, ui.left + ui.width, top, bottom, item})
+}
+
+func (ui *UI) ItemAt(x, y int) interface{} {
+	for _, r := range ui.items {
+		if x >= r.left && x < r.right && y >= r.top && y < r.bottom {
+			return r.item
+		}
+	}
+	return nil
+}
+
+// BottomRow is the first row taken by the text drawn from the bottom.
+func (ui *UI) BottomRow() int {
+	_, h := ui.tcs.Size()
+	return h - ui.bottomRows
+}
+
+// Copy puts the text into the system clipboard with the OSC 52 escape
+// sequence, which most terminal emulators understand.
+func (ui *UI) Copy(text string) {
+	ui.writeEscape("\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+}
+
+// insertPaste puts the pasted text into the input at once. Single-line
+// inputs get the lines joined.
+func (ui *UI) insertPaste(text string) {
+	if !ui.multiline {
+		text = strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", " ")
+	}
+	ui.editor.Insert(text)
+	ui.typed = ui.editor.Text()
+	ui.pasted = text
+}
+
+// Pasted returns the last text pasted into the input since it was set.
+func (ui *UI) Pasted() string {
+	return ui.pasted
+}
+
+// edit applies the key to the input field and reports whether it was an
+// editing key.
+func (ui *UI) edit(ev *tcell.EventKey) bool {
+	e := &ui.editor
+	word := ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0
+	switch ev.Key() {
+	case tcell.KeyRune:
+		if ev.Modifiers()&tcell.ModAlt == 0 {
+			e.Insert(string(ev.Rune()))
+			return true
+		}
+		switch ev.Rune() {
+		case 'b':
+			e.WordLeft()
+		case 'f':
+			e.WordRight()
+		case 'y':
+			e.YankPop()
+		default:
+			return false
+		}
+	case tcell.KeyEnter:
+		if !ui.multiline || ev.Modifiers()&(tcell.ModAlt|tcell.ModShift) == 0 {
+			return false
+		}
+		e.Insert("\n")
+	case tcell.KeyCtrlJ:
+		if !ui.multiline {
+			return false
+		}
+		e.Insert("\n")
+	case tcell.KeyUp:
+		// Up and Down leave a multi-line text only from its edges
+		return ui.multiline && e.LineUp()
+	case tcell.KeyDown:
+		return ui.multiline && e.LineDown()
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if word {
+			e.KillWord()
+		} else {
+			e.Backspace()
+		}
+	case tcell.KeyDelete:
+		e.Delete()
+	case tcell.KeyLeft:
+		if word {
+			e.WordLeft()
+		} else {
+			e.Left()
+		}
+	case tcell.KeyRight:
+		if word {
+			e.WordRight()
+		} else {
+			e.Right()
+		}
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		e.Home()
+	case tcell.KeyEnd:
+		e.End()
+	case tcell.KeyCtrlW:
+		e.KillWord()
+	case tcell.KeyCtrlU:
+		e.KillToStart()
+	case tcell.KeyCtrlK:
+		e.KillToEnd()
+	case tcell.KeyCtrlY:
+		e.Yank()
+	default:
+		return false
+	}
+	return true
+}
+
+// showCursor puts the cursor where the editor has it in the typed text,
+// which is drawn starting from the row y.
+func (ui *UI) showCursor(y int) {
+	w := ui.width
+	line, col := ui.editor.CursorCell()
+	lines := strings.Split(ui.typed, "\n")
+	for i := 0; i < line && i < len(lines); i++ {
+		y += len(wrapText(lines[i], w))
+	}
+	x := 0
+	if line < len(lines) {
+		rows := wrapText(lines[line], w)
+		for i, r := range rows {
+			if col < len(r) || i == len(rows)-1 {
+				if col > len(r) {
+					// The editor and the layout never split clusters
+					// differently, but a cursor off the row mustn't crash
+					col = len(r)
+				}
+				x = row(r[:col]).width()
+				break
+			}
+			col -= len(r)
+			y++
+		}
+	}
+	if x >= w {
+		// The cursor after a full row is at the start of the next one
+		x = 0
+		y++
+	}
+	ui.tcs.ShowCursor(ui.left+x, y)
+}
+
+// findInputEvent looks the key up in the bindings of the current state. A
+// key that starts a chord is remembered until the next one.
+func (ui *UI) findInputEvent(ev *tcell.EventKey) *InputEvent {
 	m := ui.getKeysMap()
-	e, ok := (*m)[k]
-	if !ok {
-		return nil
+	seq := keyName(ev)
+	if len(ui.chord) > 0 {
+		seq = ui.chord + " " + seq
+	}
+	ui.chord = ""
+	if e, ok := (*m)[seq]; ok {
+		return &e
+	}
+	for k := range *m {
+		if strings.HasPrefix(k, seq+" ") {
+			ui.chord = seq
+			break
+		}
 	}
-	return &e
+	return nil
 }
 
 func (ui *UI) Destroy() {
@@ -190,98 +508,87 @@ func (ui *UI) Destroy() {
 }
 
 func (ui *UI) DrawText(text string, style tcell.Style, cursor bool) {
-	s := ui.tcs
-
-	// w, h := s.Size()
 	r := ui.topRows
-	// c := 0
-
-	x, y := ui.puts(style, 0, r, text)
-	ui.topRows = y + 1
+	for _, row := range wrapText(text, ui.width) {
+		ui.drawRow(style, ui.topRows, row)
+		ui.topRows++
+	}
 	if cursor {
-		s.ShowCursor(x, y)
+		ui.showCursor(r)
 	}
 }
 
-func (ui *UI) DrawTextBottom(text string, style tcell.Style, cursor bool) {
-	s := ui.tcs
-	w, h := s.Size()
-
-	rowsAmount := int(math.Ceil(float64(utf8.RuneCountInString(text)) / float64(w)))
-	r := h - rowsAmount - ui.bottomRows
+// DrawRows draws text that is already wrapped to the width of the screen,
+// see DrawRowsBottom.
+func (ui *UI) DrawRows(rows []row, style tcell.Style) {
+	for _, row := range rows {
+		ui.drawRow(style, ui.topRows, row)
+		ui.topRows++
+	}
+}
 
-	x, y := ui.puts(style, 0, r, text)
-	ui.bottomRows += rowsAmount
+// DrawTextBottom reports whether any part of the text ended up on the screen.
+func (ui *UI) DrawTextBottom(text string, style tcell.Style, cursor bool) bool {
+	rows := wrapText(text, ui.width)
+	r := ui.BottomRow() - len(rows)
+	shown := ui.DrawRowsBottom(rows, style)
 	if cursor {
-		s.ShowCursor(x, y)
+		ui.showCursor(r)
 	}
+	return shown
 }
 
-func (ui *UI) puts(style tcell.Style, x, y int, str string) (int, int) {
-	s := ui.tcs
-
-	w, h := s.Size()
-	yMin := ui.topRows
-	yMax := h - ui.bottomRows
+// DrawRowsBottom draws text that is already wrapped to the width of the
+// screen, see textLayout. It reports whether any row ended up on the screen.
+func (ui *UI) DrawRowsBottom(rows []row, style tcell.Style) bool {
+	_, h := ui.tcs.Size()
+	r := h - len(rows) - ui.bottomRows
+	for i, row := range rows {
+		ui.drawRow(style, r+i, row)
+	}
+	ui.bottomRows += len(rows)
+	return r+len(rows) > ui.topRows
+}
 
+// DrawCells draws the text on a single row and returns its width.
+func (ui *UI) DrawCells(x, y int, text string, style tcell.Style) int {
 	i := x
-	var deferred []rune
-	dwidth := 0
-	zwj := false
-	for _, r := range str {
-		if y > yMax {
-			break
-		}
-		if r == '\u200d' {
-			if len(deferred) == 0 {
-				deferred = append(deferred, ' ')
-				dwidth = 1
-			}
-			deferred = append(deferred, r)
-			zwj = true
-			continue
-		}
-		if zwj {
-			deferred = append(deferred, r)
-			zwj = false
-			continue
+	for _, c := range layoutCells(text) {
+		ui.tcs.SetContent(i, y, c.runes[0], c.runes[1:], style)
+		i += c.width
+	}
+	return i - x
+}
+
+// FillRect paints a rectangle with the style.
+func (ui *UI) FillRect(x, y, w, h int, style tcell.Style) {
+	for row := y; row < y+h; row++ {
+		for col := x; col < x+w; col++ {
+			ui.tcs.SetContent(col, row, ' ', nil, style)
 		}
-		switch runewidth.RuneWidth(r) {
-		case 0:
-			if len(deferred) == 0 {
-				deferred = append(deferred, ' ')
-				dwidth = 1
-			}
-		case 1:
-			if len(deferred) != 0 {
-				if y >= yMin {
-					s.SetContent(x+i, y, deferred[0], deferred[1:], style)
-				}
-				i += dwidth
-			}
-			deferred = nil
-			dwidth = 1
-		case 2:
-			if len(deferred) != 0 {
-				if y >= yMin {
-					s.SetContent(x+i, y, deferred[0], deferred[1:], style)
-				}
-				i += dwidth
-			}
-			deferred = nil
-			dwidth = 2
+	}
+}
+
+// drawRow draws a row of the text in the viewport. Rows covered by the text
+// drawn from the other edge are skipped.
+func (ui *UI) drawRow(style tcell.Style, y int, r row) {
+	_, h := ui.tcs.Size()
+	if y < ui.topRows || y >= h-ui.bottomRows {
+		return
+	}
+	x := 0
+	for _, c := range r {
+		if x+c.width > ui.width {
+			break
 		}
-		deferred = append(deferred, r)
-		if i >= w {
-			y++
-			i = x
+		st := style
+		if c.attr != 0 {
+			st = markupStyle(style, c.attr)
 		}
-	}
-	if len(deferred) != 0 {
-		if y >= yMin {
-			s.SetContent(x+i, y, deferred[0], deferred[1:], style)
+		if ui.hyperlinks && len(c.link) > 0 {
+			ui.links = append(ui.links, linkCell{ui.left + x, y, c, st})
 		}
-		i += dwidth
+		ui.tcs.SetContent(ui.left+x, y, c.runes[0], c.runes[1:], st)
+		x += c.width
 	}
-	return i, y
 }